@@ -0,0 +1,107 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configCommandKey is the key of the main command in a config file.
+const configCommandKey = "command"
+
+// loadConfig applies the YAML (or JSON) config file at path to the flags
+// of fs not set on the command line, each key being a flag name and the
+// command key holding the main command. Lists set repeatable flags once
+// per item, and are joined with commas for the others. The main command is
+// a list of arguments, not split into words. It returns the main command of
+// the file, if any.
+func loadConfig(fs *flag.FlagSet, path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// Flags set on the command line take precedence over the file
+	setOnCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { setOnCommandLine[f.Name] = true })
+
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var command []string
+	for _, key := range keys {
+		values, err := configValues(config[key])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value for %q: %w", path, key, err)
+		}
+		if key == configCommandKey {
+			if _, isList := config[key].([]interface{}); !isList {
+				return nil, fmt.Errorf("%s: invalid value for %q: expected a list of arguments, like [echo, hi]", path, key)
+			}
+			command = values
+			continue
+		}
+		f := fs.Lookup(key)
+		if f == nil {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+		if setOnCommandLine[key] {
+			continue
+		}
+		if _, repeatable := f.Value.(*stringList); !repeatable {
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, value := range values {
+			if err := fs.Set(key, value); err != nil {
+				return nil, fmt.Errorf("%s: invalid value %q for %q: %w", path, value, key, err)
+			}
+		}
+	}
+	return command, nil
+}
+
+// stringList is a flag that can be repeated, collecting each value.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// configValues returns a config value as strings, one per list item.
+// Only scalars and lists of scalars are values.
+func configValues(value interface{}) ([]string, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		items = []interface{}{value}
+	}
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		switch item.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("expected a scalar or a list of scalars")
+		}
+		values = append(values, fmt.Sprint(item))
+	}
+	return values, nil
+}
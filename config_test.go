@@ -0,0 +1,50 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    []string
+		wantErr bool
+	}{
+		{"command list", "command: [echo, hi there]\n", []string{"echo", "hi there"}, false},
+		{"flags", "pre: echo pre\nenv: [A=1, B=2]\n", nil, false},
+		{"scalar command", "command: echo hi there\n", nil, true},
+		{"nested map", "pre:\n  cmd: echo pre\n", nil, true},
+		{"nested list", "env: [[A=1]]\n", nil, true},
+		{"map in command", "command: [echo, {a: b}]\n", nil, true},
+		{"unknown setting", "nope: 1\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ctx-init.yaml")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+			fs := flag.NewFlagSet("ctx-init", flag.ContinueOnError)
+			fs.String("pre", "", "")
+			var env stringList
+			fs.Var(&env, "env", "")
+			command, err := loadConfig(fs, path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadConfig() error = %v, want error %t", err, tt.wantErr)
+			}
+			if !slices.Equal(command, tt.want) {
+				t.Errorf("loadConfig() = %q, want %q", command, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+	"github.com/rs/zerolog/log"
+)
+
+// getAWSSecretValue fetches a secret from Secrets Manager, retrying
+// throttling and transient failures up to the given number of times.
+func getAWSSecretValue(ctx context.Context, client *secretsmanager.Client, input *secretsmanager.GetSecretValueInput, retries int, timeout time.Duration) (*secretsmanager.GetSecretValueOutput, error) {
+	var result *secretsmanager.GetSecretValueOutput
+	err := withRetries(ctx, retries, timeout, isRetryableAWSError, func(ctx context.Context) error {
+		var err error
+		result, err = client.GetSecretValue(ctx, input)
+		return err
+	})
+	return result, err
+}
+
+// listAWSSecretNames returns the names of the secrets of Secrets Manager
+// starting with prefix, going through every page of the listing and
+// retrying each page like getAWSSecretValue.
+func listAWSSecretNames(ctx context.Context, client *secretsmanager.Client, prefix string, retries int, timeout time.Duration) ([]string, error) {
+	paginator := secretsmanager.NewListSecretsPaginator(client, &secretsmanager.ListSecretsInput{
+		Filters: []types.Filter{{Key: types.FilterNameStringTypeName, Values: []string{prefix}}},
+	})
+	var names []string
+	for paginator.HasMorePages() {
+		var page *secretsmanager.ListSecretsOutput
+		err := withRetries(ctx, retries, timeout, isRetryableAWSError, func(ctx context.Context) error {
+			var err error
+			page, err = paginator.NextPage(ctx)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, secret := range page.SecretList {
+			// The name filter also matches words within the name
+			if name := aws.ToString(secret.Name); strings.HasPrefix(name, prefix) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// awsSecretEnvName returns the env var name of the secret name listed by an
+// aws:sm:all: reference: its last path segment upcased, with the characters
+// not allowed in env var names replaced by '_'.
+func awsSecretEnvName(name string) string {
+	name = name[strings.LastIndex(name, "/")+1:]
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// awsVersionIDPattern matches the UUIDs of secret versions.
+var awsVersionIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// awsVersionStages are the version stages managed by Secrets Manager, the
+// only ones selected by an @ suffix so names like svc/user@example.com work.
+var awsVersionStages = map[string]bool{"AWSCURRENT": true, "AWSPREVIOUS": true, "AWSPENDING": true}
+
+// awsSecretValueInput returns the input fetching the secret name, which may
+// end with an @<version-stage> or @<version-id> selector (the current
+// version by default). Any other @ suffix is part of the name.
+func awsSecretValueInput(name string) *secretsmanager.GetSecretValueInput {
+	input := &secretsmanager.GetSecretValueInput{SecretId: aws.String(name)}
+	at := strings.LastIndex(name, "@")
+	if at <= 0 {
+		return input
+	}
+	switch selector := name[at+1:]; {
+	case awsVersionIDPattern.MatchString(selector):
+		input.SecretId = aws.String(name[:at])
+		input.VersionId = aws.String(selector)
+	case awsVersionStages[selector]:
+		input.SecretId = aws.String(name[:at])
+		input.VersionStage = aws.String(selector)
+	}
+	return input
+}
+
+// awsSecretPayload returns the SecretString of a secret, or its
+// SecretBinary for binary secrets, base64-encoded unless raw is set.
+func awsSecretPayload(result *secretsmanager.GetSecretValueOutput, raw bool) string {
+	if result.SecretString != nil {
+		return *result.SecretString
+	}
+	if raw {
+		return string(result.SecretBinary)
+	}
+	return base64.StdEncoding.EncodeToString(result.SecretBinary)
+}
+
+// getAWSParameter fetches a parameter from SSM Parameter Store, retrying
+// throttling and transient failures up to the given number of times.
+func getAWSParameter(ctx context.Context, client *ssm.Client, input *ssm.GetParameterInput, retries int, timeout time.Duration) (*ssm.GetParameterOutput, error) {
+	var result *ssm.GetParameterOutput
+	err := withRetries(ctx, retries, timeout, isRetryableAWSError, func(ctx context.Context) error {
+		var err error
+		result, err = client.GetParameter(ctx, input)
+		return err
+	})
+	return result, err
+}
+
+// withFreshAWSCredentials calls fetch, then once more with the credentials
+// retrieved again if AWS rejected them as expired. The credentials cache
+// otherwise keeps using them until their own expiration time, which may
+// be too late when the web identity token (IRSA) was rotated meanwhile.
+func withFreshAWSCredentials[T any](ctx context.Context, credentials aws.CredentialsProvider, fetch func(ctx context.Context) (T, error)) (T, error) {
+	value, err := fetch(ctx)
+	cache, ok := credentials.(*aws.CredentialsCache)
+	if err == nil || !ok || !isExpiredAWSCredentialsError(err) {
+		return value, err
+	}
+	log.Debug().Err(err).Msg("AWS credentials rejected as expired, retrieving them again")
+	cache.Invalidate()
+	return fetch(ctx)
+}
+
+// isExpiredAWSCredentialsError reports whether err rejects the request
+// for its expired credentials.
+func isExpiredAWSCredentialsError(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	code := apiErr.ErrorCode()
+	return code == "ExpiredTokenException" || code == "ExpiredToken"
+}
+
+// isRetryableAWSError reports whether err is a throttling, timeout or
+// other transient error. Missing secrets and parameters are never retried.
+func isRetryableAWSError(err error) bool {
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return false
+	}
+	var paramNotFound *ssmtypes.ParameterNotFound
+	if errors.As(err, &paramNotFound) {
+		return false
+	}
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go"
+)
+
+// countingProvider is a credentials provider counting its retrievals.
+type countingProvider struct {
+	retrievals int
+}
+
+func (p *countingProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	p.retrievals++
+	return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET", Source: "test"}, nil
+}
+
+func TestWithFreshAWSCredentials(t *testing.T) {
+	tests := []struct {
+		name           string
+		firstErr       error
+		wantFetches    int
+		wantRetrievals int
+		wantErr        bool
+	}{
+		{"success", nil, 1, 1, false},
+		{"expired token", &smithy.GenericAPIError{Code: "ExpiredToken"}, 2, 2, false},
+		{"expired token exception", &smithy.GenericAPIError{Code: "ExpiredTokenException"}, 2, 2, false},
+		{"other error", &smithy.GenericAPIError{Code: "AccessDeniedException"}, 1, 1, true},
+		{"not an API error", errors.New("connection refused"), 1, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &countingProvider{}
+			cache := aws.NewCredentialsCache(provider)
+			fetches := 0
+			value, err := withFreshAWSCredentials(context.Background(), cache, func(ctx context.Context) (string, error) {
+				fetches++
+				if _, err := cache.Retrieve(ctx); err != nil {
+					return "", err
+				}
+				if fetches == 1 && tt.firstErr != nil {
+					return "", tt.firstErr
+				}
+				return "value", nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("withFreshAWSCredentials() = %q, %v, want error %t", value, err, tt.wantErr)
+			}
+			if !tt.wantErr && value != "value" {
+				t.Errorf("withFreshAWSCredentials() = %q, want %q", value, "value")
+			}
+			if fetches != tt.wantFetches {
+				t.Errorf("fetched %d times, want %d", fetches, tt.wantFetches)
+			}
+			if provider.retrievals != tt.wantRetrievals {
+				t.Errorf("retrieved the credentials %d times, want %d", provider.retrievals, tt.wantRetrievals)
+			}
+		})
+	}
+}
+
+func TestAWSSecretValueInput(t *testing.T) {
+	const versionID = "EXAMPLE1-90ab-cdef-fedc-ba987SECRET1"
+	const uuid = "3f2504e0-4f89-11d3-9a0c-0305e82c3301"
+	tests := []struct {
+		name      string
+		wantID    string
+		wantStage string
+		wantVer   string
+	}{
+		{"test/hello", "test/hello", "", ""},
+		{"test/hello@AWSPREVIOUS", "test/hello", "AWSPREVIOUS", ""},
+		{"test/hello@AWSCURRENT", "test/hello", "AWSCURRENT", ""},
+		{"test/hello@AWSPENDING", "test/hello", "AWSPENDING", ""},
+		{"test/hello@" + uuid, "test/hello", "", uuid},
+		{"test/hello@" + versionID, "test/hello@" + versionID, "", ""},
+		{"svc/user@example.com", "svc/user@example.com", "", ""},
+		{"svc/user@example.com@AWSPREVIOUS", "svc/user@example.com", "AWSPREVIOUS", ""},
+		{"test/hello@", "test/hello@", "", ""},
+		{"@AWSCURRENT", "@AWSCURRENT", "", ""},
+	}
+	for _, tt := range tests {
+		input := awsSecretValueInput(tt.name)
+		if got := aws.ToString(input.SecretId); got != tt.wantID {
+			t.Errorf("awsSecretValueInput(%q).SecretId = %q, want %q", tt.name, got, tt.wantID)
+		}
+		if got := aws.ToString(input.VersionStage); got != tt.wantStage {
+			t.Errorf("awsSecretValueInput(%q).VersionStage = %q, want %q", tt.name, got, tt.wantStage)
+		}
+		if got := aws.ToString(input.VersionId); got != tt.wantVer {
+			t.Errorf("awsSecretValueInput(%q).VersionId = %q, want %q", tt.name, got, tt.wantVer)
+		}
+	}
+}
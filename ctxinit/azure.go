@@ -0,0 +1,57 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+)
+
+// azureClients holds one Key Vault client per vault URL, all sharing the
+// same credential, created on first use since references name their vault.
+type azureClients struct {
+	credential azcore.TokenCredential
+	mu         sync.Mutex
+	byVault    map[string]*azsecrets.Client
+}
+
+// client returns the client of vaultURL, creating it if needed.
+func (c *azureClients) client(vaultURL string) (*azsecrets.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, ok := c.byVault[vaultURL]; ok {
+		return client, nil
+	}
+	client, err := azsecrets.NewClient(vaultURL, c.credential, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.byVault == nil {
+		c.byVault = make(map[string]*azsecrets.Client)
+	}
+	c.byVault[vaultURL] = client
+	return client, nil
+}
+
+// readAzureSecret returns the latest version of secret name in vaultURL.
+func (c *azureClients) readAzureSecret(ctx context.Context, vaultURL, name string) (string, error) {
+	client, err := c.client(vaultURL)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.GetSecret(ctx, name, "", nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.Value == nil {
+		return "", fmt.Errorf("no value found for secret %q", name)
+	}
+	return *resp.Value, nil
+}
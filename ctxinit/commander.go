@@ -0,0 +1,119 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// outputDrainTimeout bounds how long the output piped from a command is
+// still copied once it exited, in case background children keep it open.
+const outputDrainTimeout = time.Second
+
+// commander is a command started by start, abstracting the subprocess so
+// the signal forwarding, timeout and restart logic don't depend on exec.
+type commander interface {
+	// Start starts the command, which gets its own process group
+	Start() error
+	// Wait waits for the command to exit
+	Wait() error
+	// Pid returns the pid of the started command
+	Pid() int
+	// Signal sends sig to the process group of the started command
+	Signal(sig syscall.Signal) error
+	// SetPriority sets the scheduling priority of the process group
+	SetPriority(nice int) error
+}
+
+// newCommander returns the commander running args with opts, which start
+// uses for every command.
+var newCommander = func(args []string, opts runOptions) commander {
+	if opts.tty {
+		return newPtyCommander(args, opts)
+	}
+	return newExecCommander(args, opts)
+}
+
+// execCommander is the commander running a subprocess with exec.
+type execCommander struct {
+	cmd *exec.Cmd
+	// wrappers log the output of the command with -wrap-output, or
+	// write it line by line with -line-buffered
+	wrappers []*outputWrapper
+}
+
+// newExecCommander defines the command of args, not starting it.
+func newExecCommander(args []string, opts runOptions) *execCommander {
+	// Define command and rebind
+	// stdout, stderr and stdin if wanted
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if opts.mergeStderr {
+		cmd.Stderr = os.Stdout
+	}
+	cmd.Dir = opts.dir
+	cmd.Env = opts.env
+	if opts.stdin {
+		cmd.Stdin = os.Stdin
+	}
+	// Create a dedicated pidgroup
+	// used to forward signals to
+	// main process and all children
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: opts.credential}
+	c := &execCommander{cmd: cmd}
+	var stdout, stderr *outputWrapper
+	if opts.wrapOutput {
+		stdout = newOutputWrapper("stdout", os.Stdout, opts.wrapMaxLine)
+		stderr = newOutputWrapper("stderr", os.Stderr, opts.wrapMaxLine)
+	} else if opts.lineBuffered {
+		stdout = newLineWriter(os.Stdout, opts.wrapMaxLine)
+		stderr = newLineWriter(os.Stderr, opts.wrapMaxLine)
+	}
+	if stdout != nil {
+		if opts.mergeStderr {
+			stderr = stdout
+		}
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		// Not waiting for backgrounded children still holding the pipes
+		cmd.WaitDelay = outputDrainTimeout
+		c.wrappers = []*outputWrapper{stdout, stderr}
+	}
+	return c
+}
+
+func (c *execCommander) Start() error {
+	return c.cmd.Start()
+}
+
+func (c *execCommander) Wait() error {
+	err := c.cmd.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The command exited successfully, its output pipes
+		// were closed while its children still held them
+		err = nil
+	}
+	for _, wrapper := range c.wrappers {
+		wrapper.flush()
+	}
+	return err
+}
+
+func (c *execCommander) Pid() int {
+	return c.cmd.Process.Pid
+}
+
+func (c *execCommander) Signal(sig syscall.Signal) error {
+	return syscall.Kill(-c.cmd.Process.Pid, sig)
+}
+
+func (c *execCommander) SetPriority(nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PGRP, c.cmd.Process.Pid, nice)
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeCommander is a commander without a subprocess, which records the
+// signals it is sent and exits with the status it is given, see exit.
+type fakeCommander struct {
+	signals chan syscall.Signal
+	status  chan syscall.WaitStatus
+	// exitOn, if set, makes the command exit with status on that signal
+	exitOn     syscall.Signal
+	exitStatus syscall.WaitStatus
+}
+
+func newFakeCommander() *fakeCommander {
+	return &fakeCommander{
+		signals: make(chan syscall.Signal, signalBufferSize),
+		status:  make(chan syscall.WaitStatus, 1),
+	}
+}
+
+// exit makes the command exit with status.
+func (c *fakeCommander) exit(status syscall.WaitStatus) {
+	select {
+	case c.status <- status:
+	default: // Already exited
+	}
+}
+
+func (c *fakeCommander) Start() error {
+	return nil
+}
+
+func (c *fakeCommander) Wait() error {
+	if status := <-c.status; status != 0 {
+		return &exitStatusError{status: status}
+	}
+	return nil
+}
+
+func (c *fakeCommander) Pid() int {
+	return -1
+}
+
+func (c *fakeCommander) Signal(sig syscall.Signal) error {
+	c.signals <- sig
+	if c.exitOn != 0 && sig == c.exitOn {
+		c.exit(c.exitStatus)
+	}
+	return nil
+}
+
+func (c *fakeCommander) SetPriority(nice int) error {
+	return nil
+}
+
+// useFakeCommander makes start use c for every command until the end of
+// the test, and resets the termination state left by the test.
+func useFakeCommander(t *testing.T, c *fakeCommander) {
+	t.Helper()
+	previous := newCommander
+	newCommander = func(args []string, opts runOptions) commander { return c }
+	t.Cleanup(func() {
+		newCommander = previous
+		terminating.Store(false)
+	})
+}
+
+func TestForwardSIGTERM(t *testing.T) {
+	c := newFakeCommander()
+	c.exitOn, c.exitStatus = syscall.SIGTERM, syscall.WaitStatus(syscall.SIGTERM)
+	useFakeCommander(t, c)
+
+	p, err := start([]string{"fake"}, runOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.sigs <- syscall.SIGTERM
+	select {
+	case sig := <-c.signals:
+		if sig != syscall.SIGTERM {
+			t.Errorf("forwarded %s, want SIGTERM", signalName(sig))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SIGTERM not forwarded")
+	}
+	err = p.wait()
+	if code := exitCode(err); code != 128+int(syscall.SIGTERM) {
+		t.Errorf("exitCode(%v) = %d, want %d", err, code, 128+int(syscall.SIGTERM))
+	}
+	if !terminating.Load() {
+		t.Error("not terminating after SIGTERM")
+	}
+}
+
+func TestRemapForwardedSignal(t *testing.T) {
+	c := newFakeCommander()
+	c.exitOn = syscall.SIGQUIT
+	useFakeCommander(t, c)
+	remappedSignals = map[syscall.Signal]syscall.Signal{syscall.SIGTERM: syscall.SIGQUIT}
+	t.Cleanup(func() { remappedSignals = nil })
+
+	p, err := start([]string{"fake"}, runOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.sigs <- syscall.SIGTERM
+	if sig := <-c.signals; sig != syscall.SIGQUIT {
+		t.Errorf("forwarded %s, want SIGQUIT", signalName(sig))
+	}
+	if err := p.wait(); err != nil {
+		t.Errorf("wait() = %v, want nil", err)
+	}
+}
+
+func TestRunExitCodePropagation(t *testing.T) {
+	tests := []struct {
+		name   string
+		status syscall.WaitStatus
+		want   int
+	}{
+		{"success", 0, 0},
+		{"exit status", 3 << 8, 3},
+		{"signaled", syscall.WaitStatus(syscall.SIGSEGV), 128 + int(syscall.SIGSEGV)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeCommander()
+			c.exit(tt.status)
+			useFakeCommander(t, c)
+			code, _ := Run(context.Background(), testConfig("fake"))
+			if code != tt.want {
+				t.Errorf("Run() = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitBackgroundedOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		opts runOptions
+	}{
+		{"wrap-output", runOptions{wrapOutput: true, wrapMaxLine: 1024}},
+		{"line-buffered", runOptions{lineBuffered: true, wrapMaxLine: 1024}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The backgrounded sleep keeps the output pipes open
+			p, err := start([]string{"sh", "-c", "sleep 5 & echo done"}, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pid := p.cmd.Pid()
+			t.Cleanup(func() { syscall.Kill(-pid, syscall.SIGKILL) })
+			started := time.Now()
+			if err := p.wait(); err != nil {
+				t.Errorf("wait() = %v, want nil", err)
+			}
+			if elapsed := time.Since(started); elapsed > outputDrainTimeout+time.Second {
+				t.Errorf("wait() returned after %s, want within %s", elapsed, outputDrainTimeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import "time"
+
+// Config holds the settings of Run, each documented with the ctx-init flag
+// it is set by. Start from DefaultConfig, Run validating it as ctx-init
+// validates its flags.
+type Config struct {
+	// Command is the main command and its arguments.
+	Command []string
+	// RunCommands are the -run commands, run in parallel with Command if
+	// set, and ExitOn is -exit-on: first or all.
+	RunCommands []string
+	ExitOn      string
+
+	// PreStart, PostStop, BeforeExit, PostStart, Sidecar and PreStop are
+	// the -pre, -post, -before-exit, -post-start, -sidecar and -pre-stop
+	// commands, split into words unless Shell is set.
+	PreStart   string
+	PostStop   string
+	BeforeExit string
+	PostStart  string
+	Sidecar    string
+	PreStop    string
+	// BeforeExitTimeout is -before-exit-timeout (0 = none).
+	BeforeExitTimeout time.Duration
+	// PostStartDelay and PostStartFatal are -post-start-delay and -post-start-fatal.
+	PostStartDelay time.Duration
+	PostStartFatal bool
+	// PostOn is -post-on: always, success or failure.
+	PostOn string
+	// Shell is -shell, ShellMain is -shell-main.
+	Shell     bool
+	ShellMain bool
+
+	// TTY, MergeStderr, WrapOutput, WrapOutputMaxLine and LineBuffered are
+	// -tty, -merge-stderr, -wrap-output, -wrap-output-max-line and -line-buffered.
+	TTY               bool
+	MergeStderr       bool
+	WrapOutput        bool
+	WrapOutputMaxLine int
+	LineBuffered      bool
+
+	// Interpolate, Expand, SecretSeparator, EnvPrefix, MaxEnvScanSize and
+	// ResolveArgs are -interpolate, -expand, -secret-separator, -env-prefix,
+	// -max-env-scan-size and -resolve-args.
+	Interpolate     bool
+	Expand          bool
+	SecretSeparator string
+	EnvPrefix       string
+	MaxEnvScanSize  int
+	ResolveArgs     bool
+	// JSONEnvSkipExisting and AWSSMAll are -jsonenv-skip-existing and -aws-sm-all.
+	JSONEnvSkipExisting bool
+	AWSSMAll            bool
+	// SecretsOptional and StrictSecrets are -secrets-optional and -strict-secrets.
+	SecretsOptional bool
+	StrictSecrets   bool
+	// SecretRetries, SecretTimeout, SecretCircuitBreaker and SecretConcurrency
+	// are -secret-retries, -secret-timeout, -secret-circuit-breaker and
+	// -secret-concurrency.
+	SecretRetries        int
+	SecretTimeout        time.Duration
+	SecretCircuitBreaker int
+	SecretConcurrency    int
+
+	// AWSRegion, AssumeRoleARN, AssumeRoleExternalID, AssumeRoleSessionName
+	// and SMEndpoint are -aws-region, -assume-role-arn, -assume-role-external-id,
+	// -assume-role-session-name and -sm-endpoint (default $AWS_SM_ENDPOINT).
+	AWSRegion             string
+	AssumeRoleARN         string
+	AssumeRoleExternalID  string
+	AssumeRoleSessionName string
+	SMEndpoint            string
+
+	// Restart, MaxRestarts and RestartDelay are -restart (never, on-failure
+	// or always), -max-restarts and -restart-delay.
+	Restart      string
+	MaxRestarts  int
+	RestartDelay time.Duration
+	// Nice, Umask (octal), Chdir, User and Group are -nice, -umask, -chdir,
+	// -user and -group.
+	Nice  int
+	Umask string
+	Chdir string
+	User  string
+	Group string
+	// Timeout and KillTimeout are -timeout and -kill-timeout.
+	Timeout     time.Duration
+	KillTimeout time.Duration
+
+	// IgnoreSignals, ForwardSignals, CleanExitSignals and DebugSignal are
+	// -ignore-signals, -forward-signals, -clean-exit-signals (comma-separated)
+	// and -debug-signal, RemapSignals is -remap-signal, each SIG=SIG.
+	IgnoreSignals    string
+	ForwardSignals   string
+	CleanExitSignals string
+	DebugSignal      string
+	RemapSignals     []string
+	// ReloadOnHUP and NoForward are -reload-on-hup and -no-forward.
+	ReloadOnHUP bool
+	NoForward   bool
+	// SuccessCodes is -success-codes, like 24,2 or 24=100.
+	SuccessCodes string
+
+	// WaitFor, WaitForHTTP and WaitTimeout are -wait-for, -wait-for-http
+	// and -wait-timeout.
+	WaitFor     []string
+	WaitForHTTP []string
+	WaitTimeout time.Duration
+
+	// EnvFile and Env are -env-file and -env, each KEY=VALUE.
+	EnvFile string
+	Env     []string
+	// UnsetEnv, KeepEnv and CleanEnv are -unset-env, -keep-env and -clean-env.
+	UnsetEnv []string
+	KeepEnv  []string
+	CleanEnv bool
+	// SecretsOut is -secrets-out.
+	SecretsOut string
+
+	// LogSample is -log-sample.
+	LogSample int
+	// DryRun is -dry-run.
+	DryRun bool
+	// ReapInterval, ReapOnly and NoReap are -reap-interval, -reap-only and -no-reap.
+	ReapInterval time.Duration
+	ReapOnly     bool
+	NoReap       bool
+	// ShutdownGrace is -shutdown-grace.
+	ShutdownGrace time.Duration
+
+	// PIDFile, ReadyFile and ReadyDelay are -pidfile, -ready-file and -ready-delay.
+	PIDFile    string
+	ReadyFile  string
+	ReadyDelay time.Duration
+	// HealthAddr, GRPCHealthAddr, GRPCHealthService, GRPCHealthInterval and
+	// MetricsAddr are -health-addr, -grpc-health-addr, -grpc-health-service,
+	// -grpc-health-interval and -metrics-addr.
+	HealthAddr         string
+	GRPCHealthAddr     string
+	GRPCHealthService  string
+	GRPCHealthInterval time.Duration
+	MetricsAddr        string
+}
+
+// DefaultConfig returns the Config of ctx-init run without flags, but for
+// the main command.
+func DefaultConfig() Config {
+	return Config{
+		BeforeExitTimeout:     30 * time.Second,
+		ExitOn:                exitOnFirst,
+		PostOn:                postOnAlways,
+		WrapOutputMaxLine:     64 * 1024,
+		SecretSeparator:       separator,
+		MaxEnvScanSize:        64 * 1024,
+		SecretRetries:         3,
+		SecretTimeout:         10 * time.Second,
+		SecretConcurrency:     8,
+		AssumeRoleSessionName: component,
+		Restart:               restartNever,
+		RestartDelay:          time.Second,
+		WaitTimeout:           30 * time.Second,
+		ReapInterval:          time.Second,
+		GRPCHealthInterval:    10 * time.Second,
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// lookupCredential returns the credential to run a command as userSpec and
+// groupSpec, each a name or a numeric ID, or nil if neither is set. The
+// supplementary groups are those of the user, or only the group when set.
+// Like Docker, a numeric user missing from /etc/passwd gets group 0 unless
+// a group is set.
+func lookupCredential(userSpec string, groupSpec string) (*syscall.Credential, error) {
+	if userSpec == "" && groupSpec == "" {
+		return nil, nil
+	}
+	credential := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+	if userSpec != "" {
+		u, err := lookupUser(userSpec)
+		if err == nil {
+			uid, gid, groups, err := userIDs(u)
+			if err != nil {
+				return nil, err
+			}
+			credential.Uid, credential.Gid, credential.Groups = uid, gid, groups
+		} else if uid, convErr := strconv.ParseUint(userSpec, 10, 32); convErr == nil {
+			credential.Uid, credential.Gid, credential.Groups = uint32(uid), 0, []uint32{}
+		} else {
+			return nil, err
+		}
+	}
+	if groupSpec != "" {
+		gid, err := lookupGroupID(groupSpec)
+		if err != nil {
+			return nil, err
+		}
+		credential.Gid, credential.Groups = gid, []uint32{gid}
+	}
+	return credential, nil
+}
+
+// lookupUser returns the user named or numbered spec.
+func lookupUser(spec string) (*user.User, error) {
+	if _, err := strconv.ParseUint(spec, 10, 32); err == nil {
+		return user.LookupId(spec)
+	}
+	return user.Lookup(spec)
+}
+
+// userIDs returns the UID, primary GID and group IDs of u.
+func userIDs(u *user.User) (uint32, uint32, []uint32, error) {
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("invalid UID %q of user %q", u.Uid, u.Username)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("invalid GID %q of user %q", u.Gid, u.Username)
+	}
+	groups := []uint32{}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("cannot list the groups of user %q: %w", u.Username, err)
+	}
+	for _, groupID := range groupIDs {
+		id, err := strconv.ParseUint(groupID, 10, 32)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("invalid group ID %q of user %q", groupID, u.Username)
+		}
+		groups = append(groups, uint32(id))
+	}
+	return uint32(uid), uint32(gid), groups, nil
+}
+
+// lookupGroupID returns the GID of the group named or numbered spec.
+func lookupGroupID(spec string) (uint32, error) {
+	if gid, err := strconv.ParseUint(spec, 10, 32); err == nil {
+		return uint32(gid), nil
+	}
+	g, err := user.LookupGroup(spec)
+	if err != nil {
+		return 0, err
+	}
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid GID %q of group %q", g.Gid, spec)
+	}
+	return uint32(gid), nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// loadEnvFile sets the KEY=VALUE lines of path in the environment. Blank
+// lines and lines starting with # are ignored, and variables already set
+// in the environment take precedence over the file.
+func loadEnvFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(strings.TrimPrefix(key, "export "))
+		if !found || key == "" {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNumber)
+		}
+		value = unquote(strings.TrimSpace(value))
+
+		if _, exists := os.LookupEnv(key); exists {
+			log.Debug().Str("envVar", key).Str("envFile", path).Msg("Env var already set, ignoring value from env file")
+			continue
+		}
+		os.Setenv(key, value)
+	}
+	return scanner.Err()
+}
+
+// unquote strips a pair of matching single or double quotes around value.
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// writeEnvFile atomically writes values to path as sorted KEY=VALUE lines
+// readable by loadEnvFile, with secretFileMode. Values are single-quoted
+// when their spaces or quotes would be lost, and multi-line values, which
+// can't be written on a line, are skipped.
+func writeEnvFile(path string, values map[string]string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var content strings.Builder
+	for _, key := range keys {
+		value := values[key]
+		if strings.ContainsAny(value, "\r\n") {
+			log.Warn().Str("envVar", key).Str("envFile", path).Msg("Multi-line value can't be written to the env file, skipping it")
+			continue
+		}
+		if value != strings.TrimSpace(value) || unquote(value) != value {
+			value = "'" + value + "'"
+		}
+		fmt.Fprintf(&content, "%s=%s\n", key, value)
+	}
+	return writeFileAtomic(path, content.String(), secretFileMode)
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"path"
+	"strings"
+)
+
+// validateEnvPatterns returns an error for the first malformed glob pattern.
+func validateEnvPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesEnvPattern reports whether name matches one of the glob patterns.
+func matchesEnvPattern(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubEnv returns the KEY=VALUE entries of environ without those whose name
+// matches an unset pattern and, if keep is not empty or clean is set, those
+// matching none of its patterns. The env vars set from a secret, in secrets,
+// are kept unless matching an unset pattern.
+func scrubEnv(environ []string, unset []string, keep []string, clean bool, secrets map[string]bool) []string {
+	allowOnly := clean || len(keep) > 0
+	scrubbed := make([]string, 0, len(environ))
+	for _, entry := range environ {
+		name, _, _ := strings.Cut(entry, "=")
+		if matchesEnvPattern(name, unset) {
+			continue
+		}
+		if allowOnly && !secrets[name] && !matchesEnvPattern(name, keep) {
+			continue
+		}
+		scrubbed = append(scrubbed, entry)
+	}
+	return scrubbed
+}
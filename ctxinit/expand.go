@@ -0,0 +1,51 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// expandEnv expands the $VAR and ${VAR} references of env values, once
+// secrets are resolved so references to secret-backed vars get their
+// values. envMap holds the values before secret resolution and resolved
+// the values secrets changed. Vars set from a secret provider, including
+// interpolated values and the keys of jsonenv and aws:sm:all secrets, are
+// left as-is so a "$" in a secret value is never expanded, as are
+// references to secrets left unresolved. Expanded references are not
+// expanded again. It returns the env vars whose value changed.
+func expandEnv(envMap map[string]string, resolved map[string]string) map[string]string {
+	current := make(map[string]string, len(envMap))
+	for envName, value := range envMap {
+		current[envName] = value
+	}
+	for envName, value := range resolved {
+		current[envName] = value
+	}
+
+	expanded := make(map[string]string)
+	for envName, value := range current {
+		if _, secret := resolved[envName]; secret || refPrefix(envMap[envName]) != "" {
+			continue
+		}
+		newValue := os.Expand(value, func(name string) string {
+			if refPrefix(name) != "" {
+				return "${" + name + "}"
+			}
+			refValue, ok := current[name]
+			if !ok {
+				log.Debug().Str("envVar", envName).Str("reference", name).Msg("Undefined env var reference, expanding to empty")
+			}
+			return refValue
+		})
+		if newValue != value {
+			expanded[envName] = newValue
+		}
+	}
+	return expanded
+}
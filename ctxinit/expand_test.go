@@ -0,0 +1,36 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestExpandEnvSecretWithDollar(t *testing.T) {
+	envMap := map[string]string{
+		"DB_PASS": "aws:sm:::db",
+		"DB_URL":  "u:${aws:sm:::db}@h",
+		"DB_USER": "aws:sm:::creds:jsonenv",
+		"HOST":    "h",
+		"DSN":     "u:$DB_PASS@$HOST",
+		"DSN_RAW": "$DB_URL",
+		"MISSING": "aws:sm:::missing",
+	}
+	// Resolved from the secret pa$sword, interpolated, and as a jsonenv key
+	resolved := map[string]string{
+		"DB_PASS": "pa$sword",
+		"DB_URL":  "u:pa$sword@h",
+		"API_KEY": "k$ey",
+	}
+	want := map[string]string{
+		"DSN":     "u:pa$sword@h",
+		"DSN_RAW": "u:pa$sword@h",
+	}
+	if got := expandEnv(envMap, resolved); !maps.Equal(got, want) {
+		t.Errorf("expandEnv() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// phaseFailure is the failure of a phase of ctx-init, like the pre-start,
+// main or post-stop command, summarized by cleanQuit.
+type phaseFailure struct {
+	phase string
+	err   error
+}
+
+// phaseFailures are the failures recorded since ctx-init started, the last
+// one of each phase only, in the order the phases first failed.
+var phaseFailures struct {
+	mu       sync.Mutex
+	failures []phaseFailure
+}
+
+// recordFailure records err as the failure of phase, replacing an earlier
+// failure of the same phase (e.g. of a restarted main command).
+func recordFailure(phase string, err error) {
+	phaseFailures.mu.Lock()
+	defer phaseFailures.mu.Unlock()
+	for i, failure := range phaseFailures.failures {
+		if failure.phase == phase {
+			phaseFailures.failures[i].err = err
+			return
+		}
+	}
+	phaseFailures.failures = append(phaseFailures.failures, phaseFailure{phase: phase, err: err})
+}
+
+// resetFailures forgets the failures recorded by a previous Run.
+func resetFailures() {
+	phaseFailures.mu.Lock()
+	defer phaseFailures.mu.Unlock()
+	phaseFailures.failures = nil
+}
+
+// logFailureSummary logs the recorded failures as a single event, with the
+// exit code ctx-init exits with, if any phase failed.
+func logFailureSummary(code int) {
+	phaseFailures.mu.Lock()
+	defer phaseFailures.mu.Unlock()
+	if len(phaseFailures.failures) == 0 {
+		return
+	}
+	phases := zerolog.Dict()
+	for _, failure := range phaseFailures.failures {
+		phases.Str(failure.phase, failure.err.Error())
+	}
+	log.Error().Dict("failures", phases).Int("exitCode", code).Msg("Summary of the failed phases")
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"github.com/rs/zerolog/log"
+)
+
+// Values of -exit-on, selecting when the main commands run with -run stop.
+const (
+	exitOnFirst = "first"
+	exitOnAll   = "all"
+)
+
+// processGroup is the main command, or the main commands run in parallel
+// with -run, started and stopped together and forwarded the same signals.
+type processGroup struct {
+	procs  []*process
+	exitOn string
+}
+
+// groupExit is the exit of the command at index of a processGroup.
+type groupExit struct {
+	index int
+	err   error
+}
+
+// startGroup starts the command of each of argsList with opts, only the
+// first one reading stdin. If a command can't be started, the commands
+// already started are stopped and its error is returned.
+func startGroup(argsList [][]string, opts runOptions, exitOn string) (*processGroup, error) {
+	g := &processGroup{exitOn: exitOn}
+	for i, args := range argsList {
+		procOpts := opts
+		procOpts.stdin = opts.stdin && i == 0
+		p, err := start(args, procOpts)
+		if err != nil {
+			g.terminate()
+			for _, started := range g.procs {
+				started.wait()
+			}
+			return nil, err
+		}
+		g.procs = append(g.procs, p)
+	}
+	return g, nil
+}
+
+// pids returns the PID of each command, in the order they were started.
+func (g *processGroup) pids() []int {
+	pids := make([]int, 0, len(g.procs))
+	for _, p := range g.procs {
+		pids = append(pids, p.cmd.Pid())
+	}
+	return pids
+}
+
+// terminate terminates the commands that have not exited yet.
+func (g *processGroup) terminate() {
+	for _, p := range g.procs {
+		p.killMu.Lock()
+		exited := p.exited
+		p.killMu.Unlock()
+		if !exited {
+			p.terminate()
+		}
+	}
+}
+
+// wait waits for the commands to exit. With exitOnFirst the others are
+// terminated once the first one exits, whose error is returned. With
+// exitOnAll the first error not suppressed by isSuppressedError is
+// returned, else the error of the last command to exit.
+func (g *processGroup) wait() error {
+	if len(g.procs) == 1 {
+		return g.procs[0].wait()
+	}
+	exits := make(chan groupExit, len(g.procs))
+	for i, p := range g.procs {
+		go func() {
+			exits <- groupExit{index: i, err: p.wait()}
+		}()
+	}
+	var err error
+	for n := range len(g.procs) {
+		exit := <-exits
+		p := g.procs[exit.index]
+		withExitReason(log.Info(), exit.err).Int("pid", p.cmd.Pid()).Strs("argv", p.args).Msg("Main command of the group exited")
+		if n == 0 || (g.exitOn == exitOnAll && isSuppressedError(err)) {
+			err = exit.err
+		}
+		if n == 0 && g.exitOn == exitOnFirst {
+			log.Info().Msg("First main command of the group exited, terminating the others")
+			g.terminate()
+		}
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// grpcHealthCheckTimeout bounds each call to the gRPC health service.
+const grpcHealthCheckTimeout = time.Second
+
+// grpcHealth tracks the status of the standard gRPC health service
+// (grpc.health.v1.Health/Check) of the main command, polled by watch.
+type grpcHealth struct {
+	addr     string
+	service  string
+	interval time.Duration
+	// serving is set while the last check answered SERVING
+	serving atomic.Bool
+	// changed, if set, is called each time serving changes
+	changed func()
+}
+
+// watch checks the health service every interval until ctx is done. The
+// connection is only established once a check is made.
+func (h *grpcHealth) watch(ctx context.Context) {
+	conn, err := grpc.NewClient(h.addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Error().Err(err).Str("addr", h.addr).Msg("Cannot create the gRPC health client")
+		return
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		h.check(ctx, client)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check calls the health service once, updating serving.
+func (h *grpcHealth) check(ctx context.Context, client healthpb.HealthClient) {
+	ctx, cancel := context.WithTimeout(ctx, grpcHealthCheckTimeout)
+	defer cancel()
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: h.service})
+	serving := err == nil && resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+	if err != nil {
+		sampledDebug().Err(err).Str("addr", h.addr).Msg("gRPC health check failed")
+	}
+	if h.serving.Swap(serving) == serving {
+		return
+	}
+	log.Info().Str("addr", h.addr).Str("status", resp.GetStatus().String()).Bool("serving", serving).Msg("gRPC health status of the main command changed")
+	if h.changed != nil {
+		h.changed()
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// httpShutdownTimeout bounds how long in-flight requests may delay exit.
+const httpShutdownTimeout = 5 * time.Second
+
+// startHealthServer serves /healthz on addr in the background, answering
+// 200 while alive is set, and grpc reports serving if not nil, and 503
+// otherwise.
+func startHealthServer(addr string, alive *atomic.Bool, grpc *grpcHealth) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if !alive.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("main command not running\n"))
+			return
+		}
+		if grpc != nil && !grpc.serving.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("main command not serving gRPC health\n"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	})
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		log.Debug().Str("addr", addr).Msg("Health server listening")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Str("addr", addr).Msg("Health server failed")
+		}
+	}()
+	return server
+}
+
+// stopHTTPServer gracefully shuts down server.
+func stopHTTPServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Debug().Err(err).Str("addr", server.Addr).Msg("HTTP server shutdown failed")
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/term"
+)
+
+// SetupLogging configures the global logger from LOG_LEVEL and LOG_OUTPUT.
+// When logFile is set, logs are also appended to it (or only written to it
+// with logOnlyFile), and the file is closed once Run returns. When quiet is
+// set, only errors are logged, without colors, unless LOG_LEVEL asks for
+// debug.
+func SetupLogging(logFile string, logOnlyFile bool, quiet bool) error {
+	logLevelStr := os.Getenv("LOG_LEVEL")
+	logLevel, err := zerolog.ParseLevel(strings.ToLower(logLevelStr))
+	if logLevelStr == "" || err != nil {
+		logLevel = zerolog.WarnLevel // Default to Warn if LOG_LEVEL is not set or invalid
+	}
+	logOutput := strings.ToLower(os.Getenv("LOG_OUTPUT"))
+	if quiet {
+		if logLevel > zerolog.DebugLevel {
+			logLevel = zerolog.ErrorLevel
+		}
+		if logOutput != "json" {
+			logOutput = "nocolor"
+		}
+	}
+	log.Logger = log.Level(logLevel).With().Str("component", component).Logger()
+	// Secret values are scrubbed from the output, whatever the writers
+	log.Logger = log.Logger.Output(secretRedactor.writer(logWriter(os.Stdout, logOutput, false)))
+
+	if logFile == "" {
+		return nil
+	}
+	file, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	onQuit(func() { file.Close() })
+
+	// The file never gets colors, whatever the console output is
+	fileWriter := logWriter(file, logOutput, true)
+	if logOnlyFile {
+		log.Logger = log.Logger.Output(secretRedactor.writer(fileWriter))
+	} else {
+		log.Logger = log.Logger.Output(secretRedactor.writer(zerolog.MultiLevelWriter(logWriter(os.Stdout, logOutput, false), fileWriter)))
+	}
+	return nil
+}
+
+// logSampler, if set, samples the high-frequency debug logs of the reaper
+// and the signal forwarding. Other logs, fatal ones included, never are.
+var logSampler zerolog.Sampler
+
+// sampledDebug returns a debug event subject to logSampler.
+func sampledDebug() *zerolog.Event {
+	if logSampler == nil {
+		return log.Debug()
+	}
+	logger := log.Sample(logSampler)
+	return logger.Debug()
+}
+
+// logWriter returns the writer formatting logs to out for the LOG_OUTPUT value.
+// Without an explicit LOG_OUTPUT, colors are only used when out is a terminal.
+func logWriter(out io.Writer, logOutput string, noColor bool) io.Writer {
+	if logOutput == "nocolor" {
+		return zerolog.ConsoleWriter{Out: out, NoColor: true}
+	} else if logOutput == "json" {
+		// One JSON object per line, carrying the component field
+		return out
+	} else if logOutput == "color" {
+		return zerolog.ConsoleWriter{Out: out, NoColor: noColor}
+	}
+	return zerolog.ConsoleWriter{Out: out, NoColor: noColor || !isTerminal(out)}
+}
+
+// isTerminal reports whether out is a file attached to a terminal.
+func isTerminal(out io.Writer) bool {
+	file, ok := out.(*os.File)
+	return ok && term.IsTerminal(int(file.Fd()))
+}
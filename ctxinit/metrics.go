@@ -0,0 +1,57 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// metrics are the counters exposed on /metrics.
+var metrics struct {
+	zombiesReaped       atomic.Int64
+	secretFetches       atomic.Int64
+	secretFetchFailures atomic.Int64
+	mainRestarts        atomic.Int64
+	oomKills            atomic.Int64
+}
+
+// startMetricsServer serves /metrics on addr in the background, in the
+// Prometheus text exposition format, with main-process-up read from alive.
+func startMetricsServer(addr string, alive *atomic.Bool) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		up := 0
+		if alive.Load() {
+			up = 1
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetric(w, "ctx_init_zombies_reaped_total", "counter", "Number of orphaned processes reaped.", metrics.zombiesReaped.Load())
+		writeMetric(w, "ctx_init_secret_fetches_total", "counter", "Number of secrets fetched from the providers.", metrics.secretFetches.Load())
+		writeMetric(w, "ctx_init_secret_fetch_failures_total", "counter", "Number of secret fetches that failed.", metrics.secretFetchFailures.Load())
+		writeMetric(w, "ctx_init_main_restarts_total", "counter", "Number of restarts of the main command.", metrics.mainRestarts.Load())
+		writeMetric(w, "ctx_init_oom_kills_total", "counter", "Number of commands killed by the kernel OOM killer.", metrics.oomKills.Load())
+		writeMetric(w, "ctx_init_main_up", "gauge", "Whether the main command is running.", int64(up))
+	})
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		log.Debug().Str("addr", addr).Msg("Metrics server listening")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Str("addr", addr).Msg("Metrics server failed")
+		}
+	}()
+	return server
+}
+
+// writeMetric writes a single sample metric with its help and type.
+func writeMetric(w http.ResponseWriter, name string, kind string, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// errOOMKilled wraps the error of a command killed by SIGKILL while the
+// OOM kill count of the cgroup of ctx-init increased, see oomKillCount.
+var errOOMKilled = errors.New("killed by the OOM killer")
+
+// errUnexpectedKill wraps the error of a command killed by a SIGKILL that
+// ctx-init neither sent nor was terminating for, so not a clean exit.
+var errUnexpectedKill = errors.New("killed by an unexpected SIGKILL")
+
+// oomKillCount returns the number of processes the kernel OOM-killed in the
+// cgroup of ctx-init, from memory.events (cgroup v2) or memory.oom_control
+// (cgroup v1), or -1 if none is readable.
+func oomKillCount() int {
+	v2Path, v1Path := ownCgroupPaths()
+	candidates := []string{
+		filepath.Join(cgroupRoot, v2Path, "memory.events"),
+		filepath.Join(cgroupRoot, "memory.events"),
+		filepath.Join(cgroupRoot, "memory", v1Path, "memory.oom_control"),
+		filepath.Join(cgroupRoot, "memory", "memory.oom_control"),
+	}
+	for _, path := range candidates {
+		if count, ok := readOOMKillCount(path); ok {
+			return count
+		}
+	}
+	return -1
+}
+
+// ownCgroupPaths returns the cgroup v2 path and the cgroup v1 memory
+// controller path of ctx-init, from /proc/self/cgroup, "/" if unknown.
+func ownCgroupPaths() (string, string) {
+	v2Path, v1Path := "/", "/"
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return v2Path, v1Path
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// Lines are hierarchy-ID:controllers:path
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			v2Path = fields[2]
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			if controller == "memory" {
+				v1Path = fields[2]
+			}
+		}
+	}
+	return v2Path, v1Path
+}
+
+// readOOMKillCount returns the oom_kill field of the memory events file at
+// path, reporting whether it has one.
+func readOOMKillCount(path string) (int, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, value, _ := strings.Cut(scanner.Text(), " ")
+		if name != "oom_kill" {
+			continue
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(value))
+		return count, err == nil
+	}
+	return 0, false
+}
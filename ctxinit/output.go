@@ -0,0 +1,111 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// outputWrapper is the writer logging each line written by a command as a
+// log event of ctx-init, tagged with the stream it was written to, or with
+// lineBuffered writing each line to raw at once. Lines longer than maxLine
+// bytes (0 = no limit) are written as-is to raw instead, bounding the memory
+// buffered for a single line.
+type outputWrapper struct {
+	raw          io.Writer
+	maxLine      int
+	logger       zerolog.Logger
+	lineBuffered bool
+
+	mu  sync.Mutex
+	buf []byte
+	// passthrough is set while the rest of an overlong line is written to raw
+	passthrough bool
+}
+
+// newOutputWrapper returns the wrapper of the stream named stream, written
+// to raw for the lines too long to be wrapped.
+func newOutputWrapper(stream string, raw io.Writer, maxLine int) *outputWrapper {
+	// The output is logged at info level whatever LOG_LEVEL is, the
+	// command's own output not being subject to the verbosity of ctx-init
+	logger := log.Logger.Level(zerolog.InfoLevel).With().Str("stream", stream).Logger()
+	return &outputWrapper{raw: raw, maxLine: maxLine, logger: logger}
+}
+
+// newLineWriter returns the wrapper writing each complete line to raw with
+// a single write, so that the lines of concurrent streams don't interleave.
+func newLineWriter(raw io.Writer, maxLine int) *outputWrapper {
+	return &outputWrapper{raw: raw, maxLine: maxLine, lineBuffered: true}
+}
+
+func (w *outputWrapper) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if w.passthrough {
+			if i < 0 {
+				_, err := w.raw.Write(p)
+				return n, err
+			}
+			if _, err := w.raw.Write(p[:i+1]); err != nil {
+				return n, err
+			}
+			w.passthrough = false
+			p = p[i+1:]
+			continue
+		}
+		if i < 0 {
+			w.buf = append(w.buf, p...)
+			if w.maxLine > 0 && len(w.buf) > w.maxLine {
+				if _, err := w.raw.Write(w.buf); err != nil {
+					return n, err
+				}
+				w.buf = w.buf[:0]
+				w.passthrough = true
+			}
+			return n, nil
+		}
+		w.buf = append(w.buf, p[:i]...)
+		p = p[i+1:]
+		if w.lineBuffered || (w.maxLine > 0 && len(w.buf) > w.maxLine) {
+			if _, err := w.raw.Write(append(w.buf, '\n')); err != nil {
+				return n, err
+			}
+		} else {
+			w.log(w.buf)
+		}
+		w.buf = w.buf[:0]
+	}
+	return n, nil
+}
+
+// flush logs, or writes with lineBuffered, the last line written if it has
+// no newline, once the command exited.
+func (w *outputWrapper) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 && w.lineBuffered {
+		w.raw.Write(w.buf)
+		w.buf = w.buf[:0]
+	} else if len(w.buf) > 0 {
+		w.log(w.buf)
+		w.buf = w.buf[:0]
+	}
+	w.passthrough = false
+}
+
+// log logs line, without its trailing carriage return if any.
+func (w *outputWrapper) log(line []byte) {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	w.logger.Info().Msg(string(line))
+}
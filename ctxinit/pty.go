@@ -0,0 +1,132 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/term"
+)
+
+// ptyDrainTimeout bounds how long the output of the pty is still copied
+// once the command exited, in case background children keep it open.
+const ptyDrainTimeout = time.Second
+
+// ptyStdin is the pty of the running command, which the single goroutine
+// reading stdin copies it to, so restarts don't each leave one reading it.
+var ptyStdin struct {
+	once sync.Once
+	mu   sync.Mutex
+	ptmx *os.File
+}
+
+// setPtyStdin makes ptmx, or nothing if nil, receive stdin from now on,
+// starting to read stdin the first time.
+func setPtyStdin(ptmx *os.File) {
+	ptyStdin.mu.Lock()
+	ptyStdin.ptmx = ptmx
+	ptyStdin.mu.Unlock()
+	ptyStdin.once.Do(func() { go pumpPtyStdin() })
+}
+
+// pumpPtyStdin copies stdin to the current pty until stdin is closed,
+// dropping what is read while there is none.
+func pumpPtyStdin() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			ptyStdin.mu.Lock()
+			ptmx := ptyStdin.ptmx
+			ptyStdin.mu.Unlock()
+			if ptmx != nil {
+				ptmx.Write(buf[:n])
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ptyCommander is the commander running a subprocess in a pseudo-terminal,
+// copying stdin to it and its output to stdout.
+type ptyCommander struct {
+	*execCommander
+	ptmx *os.File
+	// drained is closed once the output of the pty is copied
+	drained chan struct{}
+	// restore resets stdin from raw mode, if it was a terminal
+	restore func()
+}
+
+// newPtyCommander defines the command of args to run in a pty, not starting it.
+func newPtyCommander(args []string, opts runOptions) *ptyCommander {
+	c := newExecCommander(args, opts)
+	// The pty is connected as stdin, stdout and stderr, and
+	// as the controlling terminal of the command's own session
+	c.cmd.Stdin, c.cmd.Stdout, c.cmd.Stderr = nil, nil, nil
+	c.cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Credential: opts.credential}
+	return &ptyCommander{execCommander: c}
+}
+
+func (c *ptyCommander) Start() error {
+	ptmx, err := pty.StartWithAttrs(c.cmd, nil, c.cmd.SysProcAttr)
+	if err != nil {
+		return err
+	}
+	c.ptmx = ptmx
+	if err := pty.InheritSize(os.Stdin, ptmx); err != nil {
+		log.Debug().Err(err).Msg("Cannot size the pty from stdin")
+	}
+
+	// Keystrokes are passed as-is, the pty handling them
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		if state, err := term.MakeRaw(fd); err != nil {
+			log.Warn().Err(err).Msg("Cannot set stdin to raw mode")
+		} else {
+			c.restore = func() { term.Restore(fd, state) }
+		}
+	}
+
+	setPtyStdin(ptmx)
+	c.drained = make(chan struct{})
+	go func() {
+		defer close(c.drained)
+		io.Copy(os.Stdout, ptmx)
+	}()
+	return nil
+}
+
+func (c *ptyCommander) Wait() error {
+	err := c.cmd.Wait()
+	select {
+	case <-c.drained:
+	case <-time.After(ptyDrainTimeout):
+	}
+	setPtyStdin(nil)
+	c.ptmx.Close()
+	if c.restore != nil {
+		c.restore()
+	}
+	return err
+}
+
+// Signal resizes the pty on SIGWINCH, the kernel then signaling its
+// foreground process group, and signals the process group otherwise.
+func (c *ptyCommander) Signal(sig syscall.Signal) error {
+	if sig == syscall.SIGWINCH {
+		return pty.InheritSize(os.Stdin, c.ptmx)
+	}
+	return c.execCommander.Signal(sig)
+}
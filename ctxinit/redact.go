@@ -0,0 +1,134 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+)
+
+// redactedValue replaces the secret values found in log output.
+const redactedValue = "***"
+
+// redactMinLength is the length below which values are not redacted, as
+// masking every occurrence of words like "info" or "true" would garble the
+// logs.
+const redactMinLength = 8
+
+// secretRedactor is the set of secret values scrubbed from log output.
+var secretRedactor = &redactor{}
+
+// redactor replaces registered values with redactedValue.
+type redactor struct {
+	mu       sync.RWMutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}
+
+// add registers a secret value to redact, both as-is and JSON-escaped
+// since log lines are JSON before any console formatting.
+func (r *redactor) add(value string) {
+	if len(value) < redactMinLength {
+		return
+	}
+	forms := []string{value}
+	var escaped bytes.Buffer
+	encoder := json.NewEncoder(&escaped)
+	encoder.SetEscapeHTML(false)
+	if encoder.Encode(value) == nil {
+		// Strip the quotes and the newline added by Encode
+		quoted := strings.TrimSuffix(escaped.String(), "\n")
+		forms = append(forms, quoted[1:len(quoted)-1])
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.values == nil {
+		r.values = make(map[string]bool)
+	}
+	for _, form := range forms {
+		r.values[form] = true
+	}
+	var oldnew []string
+	for form := range r.values {
+		oldnew = append(oldnew, form, redactedValue)
+	}
+	r.replacer = strings.NewReplacer(oldnew...)
+}
+
+// redact returns s with the registered values replaced. In a JSON log line
+// only string values are, keys and other literals are kept so the line
+// stays parseable.
+func (r *redactor) redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.replacer == nil {
+		return s
+	}
+	if !strings.HasPrefix(s, "{") {
+		return r.replacer.Replace(s)
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '"' {
+			b.WriteByte(s[i])
+			continue
+		}
+		end := jsonStringEnd(s, i+1)
+		if end < 0 {
+			// Unterminated, redact the rest
+			b.WriteByte('"')
+			b.WriteString(r.replacer.Replace(s[i+1:]))
+			break
+		}
+		content := s[i+1 : end]
+		if !strings.HasPrefix(strings.TrimLeft(s[end+1:], " \t\r\n"), ":") {
+			content = r.replacer.Replace(content)
+		}
+		b.WriteByte('"')
+		b.WriteString(content)
+		b.WriteByte('"')
+		i = end
+	}
+	return b.String()
+}
+
+// jsonStringEnd returns the index of the quote closing the JSON string of
+// s starting at from, or -1 if it is not closed.
+func jsonStringEnd(s string, from int) int {
+	for i := from; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++ // Skip the escaped character
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// writer wraps out so the registered values are redacted from each write.
+func (r *redactor) writer(out io.Writer) io.Writer {
+	return redactWriter{redactor: r, out: out}
+}
+
+// redactWriter is an io.Writer redacting secret values before writing.
+type redactWriter struct {
+	redactor *redactor
+	out      io.Writer
+}
+
+func (w redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, w.redactor.redact(string(p))); err != nil {
+		return 0, err
+	}
+	// Report the original length, redaction changes the written one
+	return len(p), nil
+}
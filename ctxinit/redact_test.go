@@ -0,0 +1,37 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r := &redactor{}
+	for _, value := range []string{"true", "info", "s3cr3t-value", `pa"ss\word`, "12345678", "message"} {
+		r.add(value)
+	}
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`{"level":"info","ok":true,"message":"got s3cr3t-value"}`, `{"level":"info","ok":true,"message":"got ***"}`},
+		{`{"message":"x pa\"ss\\word y"}`, `{"message":"x *** y"}`},
+		{`{"pid":12345678,"id":"12345678"}`, `{"pid":12345678,"id":"***"}`},
+		{`{"s3cr3t-value" : "s3cr3t-value"}`, `{"s3cr3t-value" : "***"}`},
+		{"plain s3cr3t-value\n", "plain ***\n"},
+	}
+	for _, tt := range tests {
+		got := r.redact(tt.line)
+		if got != tt.want {
+			t.Errorf("redact(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+		if tt.line[0] == '{' && !json.Valid([]byte(got)) {
+			t.Errorf("redact(%q) = %q, not valid JSON", tt.line, got)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// secretRetryBaseDelay is the delay before the first retry of a secret
+// fetch, doubled on every following attempt (200ms, 400ms, 800ms, ...).
+// Each delay is jittered by up to half of it, so that the fetches failing
+// together don't all retry at once.
+const secretRetryBaseDelay = 200 * time.Millisecond
+
+// withRetries calls fetch until it succeeds, returns an error that is not
+// retryable, or has been retried the given number of times. Each attempt
+// is bounded by timeout, and retried if it reached it.
+func withRetries(ctx context.Context, retries int, timeout time.Duration, retryable func(error) bool, fetch func(ctx context.Context) error) error {
+	delay := secretRetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := withSecretTimeout(ctx, timeout)
+		err := fetch(attemptCtx)
+		timedOut := errors.Is(attemptCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+		cancel()
+		if err == nil || attempt >= retries || !(timedOut || retryable(err)) {
+			return err
+		}
+		jittered := delay/2 + rand.N(delay)
+		log.Debug().Err(err).Int("attempt", attempt+1).Int("retries", retries).Dur("delay", jittered).Msg("Secret fetch failed, retrying")
+		time.Sleep(jittered)
+		delay *= 2
+	}
+}
+
+// withSecretTimeout returns the context of a secret fetch attempt, bounded
+// by timeout unless it is 0.
+func withSecretTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
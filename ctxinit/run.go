@@ -0,0 +1,1645 @@
+/*
+Copyright 2017 Pablo RUTH
+Copyright 2024 go-init Contributors
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+
+// Package ctxinit is the core of ctx-init, a container init resolving the
+// secret references of the environment before running the main command,
+// forwarding signals to it and reaping zombies, to embed with Run.
+package ctxinit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const separator = ":"
+const awsSecretsPrefix = "aws" + separator + "sm" + separator
+const awsParamsPrefix = "aws" + separator + "ssm" + separator
+const gcpSecretsPrefix = "gcp" + separator + "sm" + separator
+const vaultSecretsPrefix = "vault" + separator + "kv" + separator
+const azureSecretsPrefix = "azure" + separator + "kv" + separator
+
+// fileSecretsPrefix includes the only action of file references, so other
+// values starting with file: (e.g. file:// URLs) are not references
+const fileSecretsPrefix = "file" + separator + "get" + separator
+const component = "ctx-init"
+
+var logger zerolog.Logger
+
+// killTimeout is the grace period given to a command after a forwarded
+// SIGTERM/SIGINT before its process group is sent SIGKILL (0 disables it).
+var killTimeout time.Duration
+
+// ignoredSignals are never forwarded to commands, and when forwardedSignals
+// is set only the signals it contains are forwarded.
+var ignoredSignals map[syscall.Signal]bool
+var forwardedSignals map[syscall.Signal]bool
+
+// remappedSignals translates the signals forwarded to commands, ctx-init
+// itself still handling the signal it received.
+var remappedSignals map[syscall.Signal]syscall.Signal
+
+// noForward disables forwarding signals to commands, which then only get
+// the signals ctx-init sends itself (timeout and kill escalation).
+var noForward bool
+
+// reloadOnHUP restarts the main command on SIGHUP, with its secrets
+// resolved again, instead of forwarding the signal.
+var reloadOnHUP bool
+
+// debugSignal, if set, dumps the goroutine stacks of ctx-init instead of
+// being forwarded, see handleDebugSignal.
+var debugSignal syscall.Signal
+
+// cleanExitSignals are the signals a command may be killed by without
+// being logged as failed, see isSuppressedError. SIGKILL is only clean when
+// sent by ctx-init or while terminating, and never for OOM kills, see
+// classifyKill.
+var cleanExitSignals = defaultCleanExitSignals
+
+// defaultCleanExitSignals are the cleanExitSignals without -clean-exit-signals.
+var defaultCleanExitSignals = map[syscall.Signal]bool{syscall.SIGINT: true, syscall.SIGTERM: true, syscall.SIGKILL: true}
+
+// successExitCodes maps the exit codes a command may exit with without being
+// logged as failed, see isSuppressedError, to the exit code propagated.
+var successExitCodes map[int]int
+
+// signalBufferSize is the number of signals queued for a command while
+// they are not forwarded yet, e.g. while it is starting.
+const signalBufferSize = 16
+
+// defaultTimeoutKillGrace is the grace period between SIGTERM and SIGKILL
+// when a command reaches its -timeout and no -kill-timeout is set.
+const defaultTimeoutKillGrace = 10 * time.Second
+
+// timeoutExitCode is the exit code used when the main command is killed
+// for exceeding -timeout, matching GNU timeout.
+const timeoutExitCode = 124
+
+// notExecutableExitCode and notFoundExitCode are the exit codes used when
+// a command can't be executed or found, matching the shell.
+const notExecutableExitCode = 126
+const notFoundExitCode = 127
+
+// Values of -post-on, selecting which main command exits run post-stop.
+const (
+	postOnAlways  = "always"
+	postOnSuccess = "success"
+	postOnFailure = "failure"
+)
+
+// Values of -restart, selecting which main command exits are restarted.
+const (
+	restartNever     = "never"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+)
+
+// terminating is set once ctx-init received SIGTERM or SIGINT.
+var terminating atomic.Bool
+
+// errTimeout is returned by run when the command exceeded its timeout.
+var errTimeout = errors.New("command timed out")
+
+// mainAlive reports whether the main command is currently running.
+var mainAlive atomic.Bool
+
+// quitHooks are run by runQuitHooks, last registered first, before Run
+// returns.
+var quitHooks []func()
+
+// shutdownGrace is the delay before cleanQuit returns to be flushed, so the
+// last metrics can be scraped and the output of the commands drained.
+var shutdownGrace time.Duration
+
+// beforeExit, if set, runs the -before-exit command first thing in cleanQuit,
+// or once Run failed otherwise, whatever made ctx-init exit, see
+// runBeforeExit.
+var beforeExit func()
+
+// runBeforeExit runs the before-exit command, if not run yet.
+func runBeforeExit() {
+	if hook := beforeExit; hook != nil {
+		beforeExit = nil
+		hook()
+	}
+}
+
+// onQuit registers a function to be run by runQuitHooks.
+func onQuit(hook func()) {
+	quitHooks = append(quitHooks, hook)
+}
+
+// Run runs the main command of cfg as ctx-init does, with its secrets
+// resolved and its pre-start and post-stop commands, forwarding signals and
+// reaping zombies meanwhile, and returns the exit code to exit with.
+// Cancelling ctx terminates the main command like SIGTERM does.
+//
+// An invalid cfg, or a failure preventing the main command from being
+// started, is logged and returned as an error along with exit code 1.
+// Run sets state global to the process, like the signal handlers and the
+// environment, so it must only be called once per process.
+func Run(ctx context.Context, cfg Config) (int, error) {
+	defer runQuitHooks()
+
+	// Reset the state left by a previous Run
+	terminating.Store(false)
+	resetFailures()
+	killTimeout = cfg.KillTimeout
+	noForward = cfg.NoForward
+	reloadOnHUP = cfg.ReloadOnHUP
+	shutdownGrace = cfg.ShutdownGrace
+	if cfg.SMEndpoint == "" {
+		cfg.SMEndpoint = os.Getenv("AWS_SM_ENDPOINT")
+	}
+
+	// If no other args are provided, then we are missing the main command
+	if len(cfg.Command) == 0 && len(cfg.RunCommands) == 0 {
+		return fail(fatalEvent(), nil, "No main command defined, exiting")
+	}
+	if cfg.ExitOn != exitOnFirst && cfg.ExitOn != exitOnAll {
+		return fail(fatalEvent().Str("exitOn", cfg.ExitOn), nil, "Invalid -exit-on value, expected first or all")
+	}
+	for _, command := range cfg.RunCommands {
+		if len(commandArgs(command, cfg.ShellMain)) == 0 {
+			return fail(fatalEvent().Str("run", command), nil, "Invalid -run value, expected a command")
+		}
+	}
+	// Parse the hooks now, a command that can't be parsed
+	// would otherwise be skipped like an empty one
+	hooks := []struct{ flag, command string }{
+		{"pre", cfg.PreStart}, {"post", cfg.PostStop}, {"before-exit", cfg.BeforeExit},
+		{"post-start", cfg.PostStart}, {"sidecar", cfg.Sidecar}, {"pre-stop", cfg.PreStop},
+	}
+	for _, hook := range hooks {
+		if hook.command == "" || cfg.Shell {
+			continue
+		}
+		if _, err := parseArgs(hook.command); err != nil {
+			return fail(fatalEvent().Str(hook.flag, hook.command), err, "Invalid -"+hook.flag+" value, cannot parse the command")
+		}
+	}
+	if len(cfg.RunCommands) > 0 && cfg.ReapOnly {
+		return fail(fatalEvent(), nil, "Invalid -run with -reap-only, a pure reaper runs a single command")
+	}
+	if cfg.PostOn != postOnAlways && cfg.PostOn != postOnSuccess && cfg.PostOn != postOnFailure {
+		return fail(fatalEvent().Str("postOn", cfg.PostOn), nil, "Invalid -post-on value, expected always, success or failure")
+	}
+	if cfg.Restart != restartNever && cfg.Restart != restartOnFailure && cfg.Restart != restartAlways {
+		return fail(fatalEvent().Str("restart", cfg.Restart), nil, "Invalid -restart value, expected never, on-failure or always")
+	}
+	if cfg.SecretSeparator == "" {
+		return fail(fatalEvent(), nil, "Invalid -secret-separator value, expected a non-empty separator")
+	}
+	secretSeparator = cfg.SecretSeparator
+	if cfg.SecretConcurrency < 1 {
+		return fail(fatalEvent().Int("secretConcurrency", cfg.SecretConcurrency), nil, "Invalid -secret-concurrency value, expected at least 1")
+	}
+	if cfg.SecretCircuitBreaker < 0 {
+		return fail(fatalEvent().Int("secretCircuitBreaker", cfg.SecretCircuitBreaker), nil, "Invalid -secret-circuit-breaker value, expected a positive number")
+	}
+	if cfg.Chdir != "" {
+		if info, err := os.Stat(cfg.Chdir); err != nil {
+			return fail(fatalEvent(), err, "Invalid -chdir directory")
+		} else if !info.IsDir() {
+			return fail(fatalEvent().Str("chdir", cfg.Chdir), nil, "Invalid -chdir directory, not a directory")
+		}
+	}
+	credential, err := lookupCredential(cfg.User, cfg.Group)
+	if err != nil {
+		return fail(fatalEvent().Str("user", cfg.User).Str("group", cfg.Group), err, "Cannot resolve the user and group of the main command")
+	}
+	if cfg.Nice < -20 || cfg.Nice > 19 {
+		return fail(fatalEvent().Int("nice", cfg.Nice), nil, "Invalid -nice value, expected -20 to 19")
+	}
+	var umask uint64
+	if cfg.Umask != "" {
+		if umask, err = strconv.ParseUint(cfg.Umask, 8, 32); err != nil || umask > 0777 {
+			return fail(fatalEvent().Str("umask", cfg.Umask), nil, "Invalid -umask value, expected an octal mask like 0027")
+		}
+	}
+	if cfg.ReapInterval <= 0 {
+		return fail(fatalEvent().Dur("reapInterval", cfg.ReapInterval), nil, "Invalid -reap-interval value, expected a positive duration")
+	}
+	if err := validateEnvPatterns(cfg.UnsetEnv); err != nil {
+		return fail(fatalEvent(), err, "Invalid -unset-env value, expected a glob")
+	}
+	if err := validateEnvPatterns(cfg.KeepEnv); err != nil {
+		return fail(fatalEvent(), err, "Invalid -keep-env value, expected a glob")
+	}
+	if cfg.TTY && ((len(cfg.Command) > 0 && len(cfg.RunCommands) > 0) || len(cfg.RunCommands) > 1) {
+		return fail(fatalEvent(), nil, "Invalid -tty with several main commands, only one can own the terminal")
+	}
+	if cfg.WrapOutput && cfg.TTY {
+		return fail(fatalEvent(), nil, "Invalid -wrap-output with -tty, the output of a pty is not split into streams")
+	}
+	if cfg.LineBuffered && (cfg.WrapOutput || cfg.TTY) {
+		return fail(fatalEvent(), nil, "Invalid -line-buffered with -wrap-output or -tty, their output is already written line by line")
+	}
+	if cfg.MaxEnvScanSize < 0 {
+		return fail(fatalEvent().Int("maxEnvScanSize", cfg.MaxEnvScanSize), nil, "Invalid -max-env-scan-size value, expected a positive size")
+	}
+	if cfg.GRPCHealthAddr != "" && cfg.GRPCHealthInterval <= 0 {
+		return fail(fatalEvent().Dur("grpcHealthInterval", cfg.GRPCHealthInterval), nil, "Invalid -grpc-health-interval value, expected a positive duration")
+	}
+	if cfg.WrapOutputMaxLine < 0 {
+		return fail(fatalEvent().Int("wrapMaxLine", cfg.WrapOutputMaxLine), nil, "Invalid -wrap-output-max-line value, expected a positive size")
+	}
+	if cfg.ReapOnly && cfg.NoReap {
+		return fail(fatalEvent(), nil, "Invalid -reap-only with -no-reap, a pure reaper must reap zombies")
+	}
+	if cfg.LogSample < 0 {
+		return fail(fatalEvent().Int("logSample", cfg.LogSample), nil, "Invalid -log-sample value, expected a positive number")
+	} else if cfg.LogSample > 1 {
+		logSampler = &zerolog.BasicSampler{N: uint32(cfg.LogSample)}
+	} else {
+		logSampler = nil
+	}
+	if ignoredSignals, err = parseSignalList(cfg.IgnoreSignals); err != nil {
+		return fail(fatalEvent(), err, "Invalid -ignore-signals value")
+	}
+	if forwardedSignals, err = parseSignalList(cfg.ForwardSignals); err != nil {
+		return fail(fatalEvent(), err, "Invalid -forward-signals value")
+	}
+	if remappedSignals, err = parseSignalRemaps(cfg.RemapSignals); err != nil {
+		return fail(fatalEvent(), err, "Invalid -remap-signal value, expected <signal>=<signal>")
+	}
+	debugSignal = 0
+	if cfg.DebugSignal != "" {
+		if debugSignal, err = parseSignal(cfg.DebugSignal); err != nil {
+			return fail(fatalEvent(), err, "Invalid -debug-signal value")
+		}
+		if debugSignal == syscall.SIGCHLD || debugSignal == syscall.SIGTERM || debugSignal == syscall.SIGINT {
+			return fail(fatalEvent().Str("signal", cfg.DebugSignal), nil, "Invalid -debug-signal value, SIGCHLD, SIGTERM and SIGINT are used by ctx-init")
+		}
+		defer handleDebugSignal(debugSignal)()
+	}
+	if successExitCodes, err = parseSuccessCodes(cfg.SuccessCodes); err != nil {
+		return fail(fatalEvent(), err, "Invalid -success-codes value, expected exit codes like 24 or 24=0")
+	}
+	cleanExitSignals = defaultCleanExitSignals
+	if cfg.CleanExitSignals != "" {
+		if cleanExitSignals, err = parseSignalList(cfg.CleanExitSignals); err != nil {
+			return fail(fatalEvent(), err, "Invalid -clean-exit-signals value")
+		}
+	}
+
+	// As a pure reaper, none of the secrets and hooks logic applies
+	if cfg.ReapOnly {
+		return reapOnlyMain(ctx, cfg.Command, cfg.ReapInterval), nil
+	}
+
+	// Load the env file, real environment variables take precedence
+	if cfg.EnvFile != "" {
+		if err := loadEnvFile(cfg.EnvFile); err != nil {
+			return fail(fatalEvent().Str("envFile", cfg.EnvFile), err, "Cannot load the env file")
+		}
+	}
+	// Inline env vars override every other source
+	for _, pair := range cfg.Env {
+		envName, value, ok := strings.Cut(pair, "=")
+		if !ok || envName == "" {
+			return fail(fatalEvent().Str("env", pair), nil, "Invalid -env value, expected KEY=VALUE")
+		}
+		os.Setenv(envName, value)
+	}
+
+	// Create a map of environment variables
+	envMap := make(map[string]string)
+	for _, envVar := range os.Environ() {
+		pair := strings.SplitN(envVar, "=", 2)
+		if len(pair) == 2 {
+			envMap[pair[0]] = pair[1]
+		} else if len(pair) == 1 {
+			envMap[pair[0]] = "" // Handle env vars with no value
+		}
+	}
+
+	// Only the env vars matching -env-prefix are scanned for secret references,
+	// skipping huge values which can't be references but would be costly to scan
+	secretEnvMap := envMap
+	if cfg.EnvPrefix != "" || cfg.MaxEnvScanSize > 0 {
+		secretEnvMap = make(map[string]string)
+		for envName, value := range envMap {
+			if cfg.EnvPrefix != "" && !strings.HasPrefix(envName, cfg.EnvPrefix) {
+				continue
+			}
+			if cfg.MaxEnvScanSize > 0 && len(value) > cfg.MaxEnvScanSize {
+				log.Warn().Str("envVar", envName).Int("size", len(value)).Int("maxEnvScanSize", cfg.MaxEnvScanSize).Msg("Env var value too large, not scanning it for secret references")
+				continue
+			}
+			secretEnvMap[envName] = value
+		}
+	}
+
+	// Pass the raw arguments captured by flag.Args(), or the config file, to run,
+	// then the -run commands run in parallel with it
+	var mainArgs [][]string
+	if len(cfg.Command) > 0 && cfg.ShellMain {
+		mainArgs = append(mainArgs, []string{shellPath(), "-c", strings.Join(cfg.Command, " ")})
+	} else if len(cfg.Command) > 0 {
+		mainArgs = append(mainArgs, cfg.Command)
+	}
+	for _, command := range cfg.RunCommands {
+		mainArgs = append(mainArgs, commandArgs(command, cfg.ShellMain))
+	}
+
+	var preStartArgs, postStopArgs []string
+	if cfg.PreStart != "" {
+		preStartArgs = commandArgs(cfg.PreStart, cfg.Shell)
+	}
+	if cfg.PostStop != "" {
+		postStopArgs = commandArgs(cfg.PostStop, cfg.Shell)
+	}
+
+	if cfg.DryRun {
+		logDryRun(secretEnvMap, cfg.Interpolate, cfg.PreStart, cfg.PostStop, cfg.Shell, mainArgs)
+		return 0, nil
+	}
+
+	// The before-exit command is the last one run, once, whatever the exit code
+	if cfg.BeforeExit != "" {
+		beforeExitArgs := commandArgs(cfg.BeforeExit, cfg.Shell)
+		beforeExit = func() {
+			if len(beforeExitArgs) == 0 {
+				log.Debug().Msg("Before-exit command is empty, skip")
+				return
+			}
+			log.Debug().Str("command", cfg.BeforeExit).Msg("Before-exit command launched")
+			if err := run(beforeExitArgs, runOptions{timeout: cfg.BeforeExitTimeout, dir: cfg.Chdir}); err != nil {
+				log.Error().Err(err).Msg("Before-exit command failed")
+				recordFailure("before-exit", err)
+			} else {
+				log.Debug().Msg("Before-exit command exited")
+			}
+		}
+		// Run by cleanQuit, or here if Run fails before
+		defer runBeforeExit()
+	}
+
+	// Trace the startup phases, until the main command is started
+	setupTracing()
+	startupCtx, startupSpan := tracer.Start(ctx, "startup")
+
+	// Resolve the secrets into the environment, and into the command arguments
+	// when wanted, again from the references on every reload of the main command
+	argsRefs := []*[]string{&preStartArgs, &postStopArgs}
+	for i := range mainArgs {
+		argsRefs = append(argsRefs, &mainArgs[i])
+	}
+	rawArgs := make([][]string, 0, len(argsRefs))
+	for _, args := range argsRefs {
+		rawArgs = append(rawArgs, *args)
+	}
+	secretNames := make(map[string]bool)
+	resolveSecrets := func(ctx context.Context) (err error) {
+		ctx, span := tracer.Start(ctx, "resolve secrets")
+		defer func() { endSpan(span, err) }()
+
+		// Only initialize the clients of the secret providers that are referenced,
+		// by env vars or by command arguments when resolving them too
+		refValues := secretEnvMap
+		if cfg.ResolveArgs {
+			refValues = make(map[string]string, len(secretEnvMap))
+			for envName, value := range secretEnvMap {
+				refValues[envName] = value
+			}
+			for i, arg := range slices.Concat(rawArgs...) {
+				refValues[fmt.Sprintf("argv[%d]", i)] = arg
+			}
+		}
+		resolver, err := newSecretResolver(ctx, refValues, secretOptions{
+			retries:               cfg.SecretRetries,
+			timeout:               cfg.SecretTimeout,
+			circuitBreaker:        cfg.SecretCircuitBreaker,
+			awsRegion:             cfg.AWSRegion,
+			smEndpoint:            cfg.SMEndpoint,
+			assumeRoleARN:         cfg.AssumeRoleARN,
+			assumeRoleExternalID:  cfg.AssumeRoleExternalID,
+			assumeRoleSessionName: cfg.AssumeRoleSessionName,
+			interpolate:           cfg.Interpolate,
+			jsonEnvSkipExisting:   cfg.JSONEnvSkipExisting,
+			listSecrets:           cfg.AWSSMAll,
+			optional:              cfg.SecretsOptional,
+			strict:                cfg.StrictSecrets,
+		})
+		if err != nil {
+			return fmt.Errorf("cannot initialize the secret providers: %w", err)
+		}
+		defer resolver.close()
+
+		// Override environment variables that are requesting a secret to be loaded,
+		// only writing them back once all secrets were fetched
+		resolved, exploded, failures := resolver.resolveAll(ctx, secretEnvMap, cfg.SecretConcurrency)
+		// Unusable references are only failures with -strict-secrets, and stay fatal
+		var fatalFailures int
+		for _, failure := range failures {
+			if isUnusableRef(failure.err) {
+				log.Error().Err(failure.err).Str("envVar", failure.envName).Msg("Unusable secret reference in env var")
+			} else if cfg.SecretsOptional {
+				log.Warn().Err(failure.err).Str("envVar", failure.envName).Msg("Failed to retrieve secret for env var, leaving its reference")
+				continue
+			} else {
+				log.Error().Err(failure.err).Str("envVar", failure.envName).Msg("Failed to retrieve secret for env var")
+			}
+			fatalFailures++
+		}
+		if fatalFailures > 0 {
+			return fmt.Errorf("failed to retrieve secrets for %d env vars", fatalFailures)
+		}
+		var resolvedArgs [][]string
+		if cfg.ResolveArgs {
+			var changed bool
+			for _, args := range rawArgs {
+				args, argsChanged, err := resolver.resolveArgs(ctx, args)
+				if err != nil {
+					return fmt.Errorf("failed to retrieve secrets for command arguments: %w", err)
+				}
+				resolvedArgs = append(resolvedArgs, args)
+				changed = changed || argsChanged
+			}
+			if changed {
+				log.Warn().Msg("Secrets were resolved into command arguments, they are visible in the process table")
+			}
+		}
+
+		for envName, value := range resolved {
+			// Set the environment variable with the retrieved secret value
+			os.Setenv(envName, value)
+			log.Debug().Str("envVar", envName).Msg("Set env var with secret value")
+		}
+		for _, envName := range exploded {
+			// The keys of the JSON secret were set instead
+			os.Unsetenv(envName)
+		}
+		// Kept by -keep-env even when not listed
+		clear(secretNames)
+		for envName := range resolved {
+			secretNames[envName] = true
+		}
+		for i, args := range resolvedArgs {
+			*argsRefs[i] = args
+		}
+		if cfg.SecretsOut != "" {
+			if err := writeEnvFile(cfg.SecretsOut, resolved); err != nil {
+				return fmt.Errorf("cannot write the secrets to %s: %w", cfg.SecretsOut, err)
+			}
+			log.Debug().Str("secretsOut", cfg.SecretsOut).Int("envVars", len(resolved)).Msg("Wrote secret-backed env vars to file")
+		}
+
+		// Fill references to other env vars, including secret-backed ones
+		if cfg.Expand {
+			for envName, value := range expandEnv(envMap, resolved) {
+				os.Setenv(envName, value)
+				log.Debug().Str("envVar", envName).Msg("Set env var with expanded value")
+			}
+		}
+		return nil
+	}
+	if cfg.SecretsOut != "" {
+		onQuit(func() { os.Remove(cfg.SecretsOut) })
+	}
+	if err := resolveSecrets(startupCtx); err != nil {
+		return fail(fatalEvent(), err, "Cannot resolve secrets")
+	}
+
+	// The gRPC health service of the main command is polled once it started
+	var grpcChecker *grpcHealth
+	if cfg.GRPCHealthAddr != "" {
+		grpcChecker = &grpcHealth{addr: cfg.GRPCHealthAddr, service: cfg.GRPCHealthService, interval: cfg.GRPCHealthInterval}
+	}
+
+	// Serve the health endpoint for the main command
+	if cfg.HealthAddr != "" {
+		healthServer := startHealthServer(cfg.HealthAddr, &mainAlive, grpcChecker)
+		onQuit(func() { stopHTTPServer(healthServer) })
+	}
+	if cfg.MetricsAddr != "" {
+		metricsServer := startMetricsServer(cfg.MetricsAddr, &mainAlive)
+		onQuit(func() { stopHTTPServer(metricsServer) })
+	}
+
+	// Routine to reap zombies (it's the job of init), unless disabled as
+	// Wait4(-1, ...) would steal the exit status of sibling processes
+	reapCtx, cancel := context.WithCancel(context.Background())
+	var wg *sync.WaitGroup
+	if cfg.NoReap {
+		log.Debug().Msg("Zombie reaping disabled")
+	} else {
+		warnIfNotPID1()
+		wg = &sync.WaitGroup{}
+		wg.Add(1)
+		go removeZombies(reapCtx, wg, cfg.ReapInterval)
+	}
+
+	// Wait for the services the commands depend on
+	if len(cfg.WaitFor) > 0 || len(cfg.WaitForHTTP) > 0 {
+		log.Debug().Strs("targets", cfg.WaitFor).Strs("urls", cfg.WaitForHTTP).Dur("timeout", cfg.WaitTimeout).Msg("Waiting for targets")
+		waitCtx, span := tracer.Start(startupCtx, "wait for targets")
+		err := waitForTargets(waitCtx, cfg.WaitFor, cfg.WaitForHTTP, cfg.WaitTimeout)
+		endSpan(span, err)
+		if err != nil {
+			log.Error().Err(err).Msg("Wait-for targets not reachable")
+			return cleanQuit(cancel, wg, 1), fmt.Errorf("wait-for targets not reachable: %w", err)
+		}
+	}
+
+	// Launch pre-start command
+	if cfg.PreStart == "" {
+		log.Debug().Msg("No pre-start command defined, skip")
+	} else {
+		log.Debug().Str("command", cfg.PreStart).Msg("Pre-start command launched")
+		if len(preStartArgs) == 0 {
+			log.Debug().Msg("Pre-start command is empty, skip")
+		} else if err := tracedRun(startupCtx, "pre-start command", preStartArgs, runOptions{dir: cfg.Chdir}); err != nil {
+			log.Error().Msg("Pre-start command failed")
+			log.Error().Err(err).Send()
+			recordFailure("pre-start", err)
+			return cleanQuit(cancel, wg, 1), fmt.Errorf("pre-start command failed: %w", err)
+		} else {
+			log.Debug().Msg("Pre-start command exited")
+		}
+	}
+
+	// Launch the sidecar command, running in the
+	// background alongside the main command
+	var sidecar *process
+	var sidecarDone chan struct{}
+	var sidecarStopping atomic.Bool
+	if cfg.Sidecar != "" {
+		log.Debug().Str("command", cfg.Sidecar).Msg("Sidecar command launched")
+		if sidecarArgs := commandArgs(cfg.Sidecar, cfg.Shell); len(sidecarArgs) == 0 {
+			log.Debug().Msg("Sidecar command is empty, skip")
+		} else if sidecar, err = start(sidecarArgs, runOptions{dir: cfg.Chdir}); err != nil {
+			log.Error().Msg("Sidecar command failed")
+			log.Error().Err(err).Send()
+			recordFailure("sidecar", err)
+			return cleanQuit(cancel, wg, 1), fmt.Errorf("sidecar command failed: %w", err)
+		} else {
+			sidecarDone = make(chan struct{})
+			go func() {
+				defer close(sidecarDone)
+				err := sidecar.wait()
+				if sidecarStopping.Load() {
+					log.Debug().Msg("Sidecar command exited")
+				} else if err != nil {
+					log.Error().Err(err).Msg("Sidecar command failed before the main command exited")
+					recordFailure("sidecar", err)
+				} else {
+					log.Warn().Msg("Sidecar command exited before the main command")
+				}
+			}()
+		}
+	}
+
+	// Launch main command
+	// The pre-stop command runs on the first SIGTERM only
+	var preStopOnce sync.Once
+	preStop := func() {
+		preStopOnce.Do(func() {
+			log.Debug().Str("command", cfg.PreStop).Msg("Pre-stop command launched")
+			if err := run(commandArgs(cfg.PreStop, cfg.Shell), runOptions{dir: cfg.Chdir}); err != nil {
+				log.Error().Err(err).Msg("Pre-stop command failed")
+				recordFailure("pre-stop", err)
+			} else {
+				log.Debug().Msg("Pre-stop command exited")
+			}
+		})
+	}
+	if cfg.PreStop == "" {
+		preStop = nil
+	}
+
+	// The post-start command runs in the background once
+	// the main command is started and still running after the delay
+	var postStartFailed atomic.Bool
+	postStart := func(group *processGroup) {
+		if cfg.PostStart == "" {
+			return
+		}
+		go func() {
+			time.Sleep(cfg.PostStartDelay)
+			if !mainAlive.Load() {
+				log.Debug().Msg("Main command not running anymore, skip post-start command")
+				return
+			}
+			log.Debug().Str("command", cfg.PostStart).Msg("Post-start command launched")
+			if err := run(commandArgs(cfg.PostStart, cfg.Shell), runOptions{dir: cfg.Chdir}); err != nil {
+				log.Error().Err(err).Msg("Post-start command failed")
+				recordFailure("post-start", err)
+				if cfg.PostStartFatal {
+					postStartFailed.Store(true)
+					terminating.Store(true)
+					for _, pid := range group.pids() {
+						syscall.Kill(-pid, syscall.SIGTERM)
+					}
+				}
+			} else {
+				log.Debug().Msg("Post-start command exited")
+			}
+		}()
+	}
+
+	// The pidfile is rewritten on every restart of the main command
+	if cfg.PIDFile != "" {
+		onQuit(func() { os.Remove(cfg.PIDFile) })
+	}
+
+	// The ready file exists once the main command ran for the delay, while
+	// its gRPC health service is serving if checked, and is removed as soon
+	// as it exits
+	var readyMu sync.Mutex
+	var readyElapsed, readyWritten bool
+	syncReadyFile := func() {
+		readyMu.Lock()
+		defer readyMu.Unlock()
+		ready := readyElapsed && (grpcChecker == nil || grpcChecker.serving.Load())
+		if ready == readyWritten {
+			return
+		}
+		if !ready {
+			os.Remove(cfg.ReadyFile)
+			log.Debug().Str("readyFile", cfg.ReadyFile).Msg("Main command not ready anymore")
+		} else if err := writeFileAtomic(cfg.ReadyFile, "", 0644); err != nil {
+			log.Warn().Err(err).Str("readyFile", cfg.ReadyFile).Msg("Cannot write the ready file")
+			return
+		} else {
+			log.Debug().Str("readyFile", cfg.ReadyFile).Msg("Main command ready")
+		}
+		readyWritten = ready
+	}
+	setReadyElapsed := func(elapsed bool) {
+		readyMu.Lock()
+		readyElapsed = elapsed
+		readyMu.Unlock()
+		syncReadyFile()
+	}
+	markReady := func(exited <-chan struct{}) {
+		select {
+		case <-exited:
+			return
+		case <-time.After(cfg.ReadyDelay):
+		}
+		setReadyElapsed(true)
+	}
+	if cfg.ReadyFile != "" {
+		onQuit(func() { os.Remove(cfg.ReadyFile) })
+		if grpcChecker != nil {
+			grpcChecker.changed = syncReadyFile
+		}
+	}
+	if grpcChecker != nil {
+		grpcCtx, stopGRPCChecks := context.WithCancel(context.Background())
+		onQuit(stopGRPCChecks)
+		go grpcChecker.watch(grpcCtx)
+	}
+
+	// A SIGHUP resolves the secrets again, then stops the main command
+	// so it is restarted with them, keeping it running if that fails
+	var reloading atomic.Bool
+	hups := make(chan os.Signal, 1)
+	if reloadOnHUP {
+		signal.Notify(hups, syscall.SIGHUP)
+		defer signal.Stop(hups)
+	}
+	watchReload := func(p *processGroup, exited <-chan struct{}) {
+		for {
+			select {
+			case <-exited:
+				return
+			case <-ctx.Done():
+				log.Info().Msg("Context of ctx-init done, terminating the main command")
+				terminating.Store(true)
+				p.terminate()
+				return
+			case <-hups:
+				log.Info().Msg("SIGHUP received, reloading the main command")
+				if err := resolveSecrets(context.Background()); err != nil {
+					log.Error().Err(err).Msg("Cannot reload the secrets, keeping the main command running")
+					continue
+				}
+				reloading.Store(true)
+				p.terminate()
+				return
+			}
+		}
+	}
+
+	var mainRC int
+	var mainErr error
+	for restarts := 0; ; restarts++ {
+		mainRC, mainErr = 0, nil
+		_, launchSpan := tracer.Start(startupCtx, "start main command")
+		var mainEnv []string
+		if len(cfg.UnsetEnv) > 0 || len(cfg.KeepEnv) > 0 || cfg.CleanEnv {
+			mainEnv = scrubEnv(os.Environ(), cfg.UnsetEnv, cfg.KeepEnv, cfg.CleanEnv, secretNames)
+		}
+		mainGroup, err := startGroup(mainArgs, runOptions{
+			env:          mainEnv,
+			timeout:      cfg.Timeout,
+			dir:          cfg.Chdir,
+			stdin:        true,
+			credential:   credential,
+			preStop:      preStop,
+			nice:         cfg.Nice,
+			setUmask:     cfg.Umask != "",
+			umask:        int(umask),
+			tty:          cfg.TTY,
+			mergeStderr:  cfg.MergeStderr,
+			wrapOutput:   cfg.WrapOutput,
+			wrapMaxLine:  cfg.WrapOutputMaxLine,
+			lineBuffered: cfg.LineBuffered,
+		}, cfg.ExitOn)
+		endSpan(launchSpan, err)
+		startupSpan.End() // A no-op after the first start
+		if err == nil {
+			mainAlive.Store(true)
+			for _, p := range mainGroup.procs {
+				log.Info().Int("pid", p.cmd.Pid()).Strs("argv", p.args).Msg("Main command started")
+			}
+			if cfg.PIDFile != "" {
+				if err := writeFileAtomic(cfg.PIDFile, strconv.Itoa(mainGroup.pids()[0])+"\n", 0644); err != nil {
+					log.Warn().Err(err).Str("pidFile", cfg.PIDFile).Msg("Cannot write the pidfile")
+				}
+			}
+			postStart(mainGroup)
+			exited := make(chan struct{})
+			watched := make(chan struct{})
+			go func() {
+				defer close(watched)
+				watchReload(mainGroup, exited)
+			}()
+			readied := make(chan struct{})
+			go func() {
+				defer close(readied)
+				if cfg.ReadyFile != "" {
+					markReady(exited)
+				}
+			}()
+			err = mainGroup.wait()
+			close(exited)
+			<-watched
+			<-readied
+			if cfg.ReadyFile != "" {
+				setReadyElapsed(false)
+			}
+		}
+		mainAlive.Store(false)
+		if err != nil {
+			if errors.Is(err, errTimeout) {
+				withExitReason(log.Error(), err).Err(err).Msg("Main command failed")
+				mainRC, mainErr = timeoutExitCode, err
+			} else if isSuppressedError(err) {
+				log.Debug().Msg("Main command exited") // Suppress "failed"
+				mainRC = successExitCode(err)
+			} else {
+				withExitReason(log.Error(), err).Msg("Main command failed")
+				log.Error().Err(err).Send()
+				mainRC, mainErr = exitCode(err), err
+			}
+		} else {
+			log.Debug().Msg("Main command exited")
+		}
+		if postStartFailed.Load() {
+			mainRC = 1
+		}
+		withExitReason(log.Info(), err).Int("exitCode", mainRC).Msg("Main command terminated")
+
+		// Restart the main command if reloaded, or if wanted, unless ctx-init is terminating
+		if reloading.Swap(false) && !terminating.Load() {
+			log.Info().Msg("Restarting main command to reload it")
+			restarts-- // A reload is not a restart
+			continue
+		}
+		if cfg.Restart == restartNever || (cfg.Restart == restartOnFailure && mainRC == 0) {
+			break
+		}
+		if terminating.Load() {
+			log.Debug().Msg("Termination requested, not restarting main command")
+			break
+		}
+		if cfg.MaxRestarts > 0 && restarts >= cfg.MaxRestarts {
+			log.Warn().Int("maxRestarts", cfg.MaxRestarts).Msg("Main command reached the maximum number of restarts")
+			break
+		}
+		log.Warn().Int("exitCode", mainRC).Int("restart", restarts+1).Dur("delay", cfg.RestartDelay).Msg("Restarting main command")
+		if !sleepUnlessTerminated(ctx, cfg.RestartDelay) {
+			log.Debug().Msg("Termination requested, not restarting main command")
+			break
+		}
+		metrics.mainRestarts.Add(1)
+	}
+
+	// Terminate the sidecar command, unless it already exited
+	if sidecarDone != nil {
+		sidecarStopping.Store(true)
+		select {
+		case <-sidecarDone:
+		default:
+			sidecar.terminate()
+			<-sidecarDone
+		}
+	}
+
+	// Only the last run of the main command is summarized
+	if mainErr != nil {
+		recordFailure("main", mainErr)
+	}
+
+	// Launch post-stop command, its failure only setting the exit
+	// code if the main command succeeded
+	if cfg.PostStop == "" {
+		log.Debug().Msg("No post-stop command defined, skip")
+	} else if (cfg.PostOn == postOnSuccess && mainRC != 0) || (cfg.PostOn == postOnFailure && mainRC == 0) {
+		log.Debug().Str("postOn", cfg.PostOn).Int("exitCode", mainRC).Msg("Post-stop command not wanted for this main command exit, skip")
+	} else {
+		log.Debug().Str("command", cfg.PostStop).Msg("Post-stop command launched")
+		if len(postStopArgs) == 0 {
+			log.Debug().Msg("Post-stop command is empty, skip")
+		} else if err := run(postStopArgs, runOptions{dir: cfg.Chdir}); err != nil {
+			log.Error().Msg("Post-stop command failed")
+			log.Error().Err(err).Send()
+			recordFailure("post-stop", err)
+			if mainRC == 0 {
+				mainRC = 1
+			}
+		} else {
+			log.Debug().Msg("Post-stop command exited")
+		}
+	}
+
+	// Wait removeZombies goroutine
+	return cleanQuit(cancel, wg, mainRC), nil
+}
+
+// reapOnlyMain runs args as the only command, forwarding signals to it and
+// reaping zombies until it exits, then returns its exit code. Cancelling ctx
+// terminates it.
+func reapOnlyMain(ctx context.Context, args []string, reapInterval time.Duration) int {
+	warnIfNotPID1()
+	reapCtx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go removeZombies(reapCtx, wg, reapInterval)
+
+	var code int
+	p, err := start(args, runOptions{stdin: true})
+	if err == nil {
+		stop := context.AfterFunc(ctx, func() {
+			terminating.Store(true)
+			p.terminate()
+		})
+		err = p.wait()
+		stop()
+	}
+	if err != nil && !isSuppressedError(err) {
+		withExitReason(log.Error(), err).Msg("Main command failed")
+		log.Error().Err(err).Send()
+		code = exitCode(err)
+	} else {
+		code = successExitCode(err)
+	}
+	return cleanQuit(cancel, wg, code)
+}
+
+// warnIfNotPID1 warns that zombies may pile up when ctx-init is not PID 1, as
+// orphaned processes are then reparented to the real init instead, e.g. when
+// started by a shell with the string form of a Dockerfile CMD.
+func warnIfNotPID1() {
+	if pid := os.Getpid(); pid != 1 {
+		log.Warn().Int("pid", pid).Msg("Not running as PID 1, orphaned zombies may not be reaped, use the exec form of CMD/ENTRYPOINT (e.g. [\"ctx-init\", \"--\", \"my_command\"]) or run ctx-init as PID 1, or -no-reap when wrapping a command")
+	}
+}
+
+func removeZombies(ctx context.Context, wg *sync.WaitGroup, interval time.Duration) {
+	// Get notified of children exiting, SIGCHLD
+	// is never forwarded so it is only used here
+	sigchld := make(chan os.Signal, 1)
+	signal.Notify(sigchld, syscall.SIGCHLD)
+	defer signal.Stop(sigchld)
+
+	// Also poll in case a SIGCHLD is missed
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Reap every zombie already waiting,
+		// SIGCHLD signals may have been coalesced
+		for reapZombie() {
+		}
+
+		// Block until a child exits, the poll
+		// interval elapses or the context is done
+		select {
+		case <-ctx.Done():
+			// Context is done
+			// so we stop goroutine
+			wg.Done()
+			return
+		case <-sigchld:
+		case <-ticker.C:
+		}
+	}
+}
+
+// reapZombie reaps one waiting child, if any, and reports whether one was
+// reaped or reaping should be retried right away. The exit status of
+// children tracked by run is relayed back to it.
+func reapZombie() bool {
+	children.mu.Lock()
+	defer children.mu.Unlock()
+
+	var status syscall.WaitStatus
+	pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+	if errors.Is(err, syscall.EINTR) {
+		// Interrupted by a signal, nothing was collected yet
+		return true
+	}
+	if err != nil && !errors.Is(err, syscall.ECHILD) {
+		// ECHILD only means there are no children at all,
+		// both wait for the next SIGCHLD or poll
+		log.Debug().Err(err).Msg("Cannot reap zombies")
+	}
+	if pid <= 0 {
+		// PID is 0 or -1 if no child waiting
+		return false
+	}
+	if ch, ok := children.tracked[pid]; ok {
+		ch <- status
+	} else {
+		metrics.zombiesReaped.Add(1)
+		sampledDebug().Int("pid", pid).Msg("Reaped zombie")
+	}
+	return true
+}
+
+// children tracks the commands started by run, so that an exit status
+// collected by the reaper before cmd.Wait can be handed back to run.
+var children = struct {
+	mu      sync.Mutex
+	tracked map[int]chan syscall.WaitStatus
+}{tracked: make(map[int]chan syscall.WaitStatus)}
+
+// exitStatusError is the error for a command whose exit status was
+// collected by the reaper, mirroring *exec.ExitError.
+type exitStatusError struct {
+	status syscall.WaitStatus
+}
+
+func (e *exitStatusError) Error() string {
+	if e.status.Signaled() {
+		return "signal: " + e.status.Signal().String()
+	}
+	return fmt.Sprintf("exit status %d", e.status.ExitStatus())
+}
+
+// waitStatusOf returns the wait status carried by an error returned by run.
+func waitStatusOf(err error) (syscall.WaitStatus, bool) {
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		status, ok := exitError.Sys().(syscall.WaitStatus)
+		return status, ok
+	}
+	var statusError *exitStatusError
+	if errors.As(err, &statusError) {
+		return statusError.status, true
+	}
+	return 0, false
+}
+
+// runOptions holds the per-command settings of run.
+type runOptions struct {
+	// timeout is the wall-clock limit of the command, 0 disables it
+	timeout time.Duration
+	// dir, if set, is the working directory of the command
+	dir string
+	// stdin passes the stdin of ctx-init to the command
+	stdin bool
+	// credential, if set, is the user and groups to run the command as
+	credential *syscall.Credential
+	// env, if set, is the environment of the command instead of ctx-init's
+	env []string
+	// preStop, if set, is called on SIGTERM before forwarding it
+	preStop func()
+	// nice, if not 0, is the scheduling priority of the command
+	nice int
+	// umask, if setUmask, is the umask the command is started with
+	setUmask bool
+	umask    int
+	// tty runs the command in a pty connected to stdin and stdout
+	tty bool
+	// mergeStderr writes the stderr of the command to stdout
+	mergeStderr bool
+	// wrapOutput logs each line of output of the command, up to
+	// wrapMaxLine bytes (0 = no limit), see outputWrapper
+	wrapOutput  bool
+	wrapMaxLine int
+	// lineBuffered writes the output of the command line by line, up to
+	// wrapMaxLine bytes too
+	lineBuffered bool
+}
+
+// process is a command started by start, whose exit is waited with wait.
+type process struct {
+	cmd  commander
+	args []string
+	opts runOptions
+	sigs chan os.Signal
+	// forwarded is closed once forwardSignals returned
+	forwarded chan struct{}
+	// reaped receives the exit status if the reaper collects the command
+	reaped chan syscall.WaitStatus
+
+	// Timer escalating to SIGKILL, guarded so it
+	// can be cancelled once the command has exited
+	killMu    sync.Mutex
+	killTimer *time.Timer
+	exited    bool
+
+	timeoutTimer *time.Timer
+	timedOut     atomic.Bool
+
+	// oomKills is the OOM kill count of the cgroup when the command
+	// started, and sentKill is set once ctx-init sent it SIGKILL
+	oomKills int
+	sentKill atomic.Bool
+}
+
+// run starts the command of args and waits for it to exit.
+func run(args []string, opts runOptions) error {
+	if len(args) == 0 {
+		return nil // No command to run
+	}
+	p, err := start(args, opts)
+	if err != nil {
+		return err
+	}
+	return p.wait()
+}
+
+// start starts the command of args, which must not be empty, in its own
+// process group, forwarding signals to it until wait returns.
+func start(args []string, opts runOptions) (*process, error) {
+	// Register chan to receive system signals, from before the start
+	// so signals arriving during startup are queued, not lost
+	p := &process{args: args, opts: opts, sigs: make(chan os.Signal, signalBufferSize)}
+	signal.Notify(p.sigs)
+	p.cmd = newCommander(args, opts)
+	p.oomKills = oomKillCount()
+
+	// Start defined command, tracking it before
+	// the reaper gets a chance to collect it
+	p.reaped = make(chan syscall.WaitStatus, 1)
+	children.mu.Lock()
+	// The umask is process-wide, so it is only set while the
+	// command is started, then restored for ctx-init
+	var previousUmask int
+	if opts.setUmask {
+		previousUmask = syscall.Umask(opts.umask)
+	}
+	err := p.cmd.Start()
+	if opts.setUmask {
+		syscall.Umask(previousUmask)
+	}
+	if err == nil {
+		children.tracked[p.cmd.Pid()] = p.reaped
+	}
+	children.mu.Unlock()
+	if err != nil {
+		signal.Stop(p.sigs)
+		close(p.sigs)
+		if isNotFound(err) {
+			log.Error().Str("command", args[0]).Msg("Command not found")
+		} else if errors.Is(err, syscall.EACCES) {
+			log.Error().Str("command", args[0]).Msg("Command not executable, permission denied")
+		}
+		return nil, err
+	}
+
+	// Goroutine for signals forwarding, only started once
+	// the process exists, it first drains the queued signals
+	p.forwarded = make(chan struct{})
+	go p.forwardSignals()
+
+	// Lower (or raise) the priority of the whole process group,
+	// including any child the command already forked
+	pid := p.cmd.Pid()
+	if opts.nice != 0 {
+		if err := p.cmd.SetPriority(opts.nice); err != nil {
+			log.Warn().Err(err).Int("nice", opts.nice).Msg("Cannot set the scheduling priority of the command")
+		} else {
+			log.Debug().Int("nice", opts.nice).Int("pid", pid).Msg("Set the scheduling priority of the command")
+		}
+	}
+
+	// Enforce the wall-clock limit, terminating
+	// the process group once it is reached
+	if opts.timeout > 0 {
+		p.timeoutTimer = time.AfterFunc(opts.timeout, func() {
+			p.timedOut.Store(true)
+			log.Warn().Dur("timeout", opts.timeout).Msg("Command timed out, sending SIGTERM")
+			p.terminate()
+		})
+	}
+	return p, nil
+}
+
+// forwardSignals forwards the signals received by ctx-init to the process
+// group of the command, until the signals channel is closed by wait. A
+// second forwarded SIGTERM/SIGINT kills the process group right away.
+func (p *process) forwardSignals() {
+	defer close(p.forwarded)
+	terminations := 0
+	for sig := range p.sigs {
+		if sig == syscall.SIGTERM || sig == syscall.SIGINT {
+			terminating.Store(true)
+		}
+		if sig == syscall.SIGTERM && p.opts.preStop != nil {
+			p.opts.preStop()
+		}
+
+		// Ignore SIGCHLD signals since
+		// thez are only usefull for ctx-init,
+		// and any signal configured as ignored
+		if shouldForward(sig.(syscall.Signal)) {
+			// Forward signal to main process and all children,
+			// translated if remapped
+			forwarded := sig.(syscall.Signal)
+			if remapped, ok := remappedSignals[forwarded]; ok {
+				forwarded = remapped
+			}
+			p.cmd.Signal(forwarded)
+			sampledDebug().Str("signal", signalName(sig.(syscall.Signal))).Str("forwarded", signalName(forwarded)).Int("pid", p.cmd.Pid()).Msg("Forwarded signal to command")
+
+			// Start the kill timer on the first termination signal,
+			// and kill without waiting for it on the next one
+			if sig == syscall.SIGTERM || sig == syscall.SIGINT {
+				terminations++
+				if terminations > 1 {
+					p.forceKill(sig.(syscall.Signal))
+				} else if killTimeout > 0 {
+					p.scheduleKill(killTimeout)
+				}
+			}
+		}
+	}
+}
+
+// terminate sends SIGTERM to the process group of the command, then
+// SIGKILL if it is still running after the kill timeout.
+func (p *process) terminate() {
+	p.cmd.Signal(syscall.SIGTERM)
+	grace := killTimeout
+	if grace <= 0 {
+		grace = defaultTimeoutKillGrace
+	}
+	p.scheduleKill(grace)
+}
+
+// scheduleKill sends SIGKILL to the process group of the command after
+// grace, unless already scheduled or the command has exited.
+func (p *process) scheduleKill(grace time.Duration) {
+	p.killMu.Lock()
+	defer p.killMu.Unlock()
+	if p.killTimer == nil && !p.exited {
+		p.killTimer = time.AfterFunc(grace, func() {
+			log.Warn().Dur("grace", grace).Msg("Command did not exit within grace period, sending SIGKILL")
+			p.sentKill.Store(true)
+			p.cmd.Signal(syscall.SIGKILL)
+		})
+	}
+}
+
+// forceKill sends SIGKILL to the process group of the command right away,
+// on a repeated termination signal, unless it has exited.
+func (p *process) forceKill(sig syscall.Signal) {
+	p.killMu.Lock()
+	defer p.killMu.Unlock()
+	if p.exited {
+		return
+	}
+	if p.killTimer != nil {
+		p.killTimer.Stop()
+	}
+	log.Warn().Str("signal", signalName(sig)).Int("pid", p.cmd.Pid()).Msg("Termination signal received again, force-killing the command with SIGKILL")
+	p.sentKill.Store(true)
+	p.cmd.Signal(syscall.SIGKILL)
+}
+
+// wait waits for the command to exit, then stops forwarding signals to
+// it. It returns errTimeout if the command reached its timeout.
+func (p *process) wait() error {
+	defer func() {
+		if p.timeoutTimer != nil {
+			p.timeoutTimer.Stop()
+		}
+		children.mu.Lock()
+		delete(children.tracked, p.cmd.Pid())
+		children.mu.Unlock()
+		signal.Stop(p.sigs)
+		close(p.sigs)
+		<-p.forwarded
+	}()
+
+	// Wait for command to exit
+	err := p.cmd.Wait()
+	if errors.Is(err, syscall.ECHILD) {
+		// The reaper collected the command first, the
+		// lock ensures it has relayed the exit status
+		children.mu.Lock()
+		children.mu.Unlock()
+		select {
+		case status := <-p.reaped:
+			err = nil
+			if status != 0 {
+				err = &exitStatusError{status: status}
+			}
+		default:
+		}
+	}
+
+	// Cancel a pending kill so it can't hit a reused pid
+	p.killMu.Lock()
+	p.exited = true
+	if p.killTimer != nil {
+		p.killTimer.Stop()
+	}
+	p.killMu.Unlock()
+
+	if status, ok := waitStatusOf(err); ok && status.Signaled() && status.Signal() == syscall.SIGKILL {
+		err = p.classifyKill(err)
+	}
+	if p.timedOut.Load() {
+		return fmt.Errorf("%w after %s", errTimeout, p.opts.timeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// classifyKill returns err, of the command killed by SIGKILL, wrapped with
+// errOOMKilled if the OOM kill count of the cgroup increased since it
+// started, or with errUnexpectedKill if ctx-init neither sent SIGKILL nor
+// was terminating, so the kill is not taken for a clean exit.
+func (p *process) classifyKill(err error) error {
+	if p.oomKills >= 0 && oomKillCount() > p.oomKills {
+		metrics.oomKills.Add(1)
+		log.Error().Int("pid", p.cmd.Pid()).Strs("argv", p.args).Msg("Command killed by the kernel OOM killer, its cgroup is out of memory")
+		return fmt.Errorf("%w: %w", errOOMKilled, err)
+	}
+	if !p.sentKill.Load() && !terminating.Load() {
+		log.Warn().Int("pid", p.cmd.Pid()).Strs("argv", p.args).Msg("Command killed by SIGKILL neither sent by ctx-init nor while terminating, not a clean exit")
+		return fmt.Errorf("%w: %w", errUnexpectedKill, err)
+	}
+	return err
+}
+
+// logDryRun logs the commands and the secret references of each env var
+// that a run would use, at info level or lower. Only references are
+// logged, never secret values.
+func logDryRun(envMap map[string]string, interpolate bool, preStartCmd string, postStopCmd string, useShell bool, mainArgs [][]string) {
+	if log.Logger.GetLevel() > zerolog.InfoLevel {
+		log.Logger = log.Logger.Level(zerolog.InfoLevel)
+	}
+	envNames := make([]string, 0, len(envMap))
+	for envName := range envMap {
+		envNames = append(envNames, envName)
+	}
+	sort.Strings(envNames)
+	for _, envName := range envNames {
+		if refs := secretRefs(envMap[envName], interpolate); len(refs) > 0 {
+			log.Info().Str("envVar", envName).Strs("refs", refs).Msg("Dry run: env var would be overridden by secrets")
+		}
+	}
+	if preStartCmd != "" {
+		log.Info().Strs("argv", commandArgs(preStartCmd, useShell)).Msg("Dry run: pre-start command would run")
+	}
+	for _, args := range mainArgs {
+		log.Info().Strs("argv", args).Msg("Dry run: main command would run")
+	}
+	if postStopCmd != "" {
+		log.Info().Strs("argv", commandArgs(postStopCmd, useShell)).Msg("Dry run: post-stop command would run")
+	}
+}
+
+// sleepUnlessTerminated waits for delay and reports whether it elapsed
+// without ctx-init receiving SIGTERM or SIGINT, nor ctx being done.
+func sleepUnlessTerminated(ctx context.Context, delay time.Duration) bool {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigs)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-sigs:
+		terminating.Store(true)
+		return false
+	case <-ctx.Done():
+		terminating.Store(true)
+		return false
+	}
+}
+
+// cleanQuit runs the before-exit command, stops reaping zombies and waits
+// for the shutdown grace, then returns code for Run to return once its quit
+// hooks ran.
+func cleanQuit(cancel context.CancelFunc, wg *sync.WaitGroup, code int) int {
+	// Run the finalizer while zombies are still reaped
+	runBeforeExit()
+	logFailureSummary(code)
+
+	// Signal zombie goroutine to stop
+	// and wait for it to release waitgroup, if reaping
+	cancel()
+	if wg != nil {
+		wg.Wait()
+	}
+	if shutdownGrace > 0 {
+		log.Debug().Dur("shutdownGrace", shutdownGrace).Msg("Waiting before exiting")
+		time.Sleep(shutdownGrace)
+	}
+	return code
+}
+
+// runQuitHooks flushes the exporters and closes the files, last opened first
+// closed, whether Run succeeded or failed.
+func runQuitHooks() {
+	for i := len(quitHooks) - 1; i >= 0; i-- {
+		quitHooks[i]()
+	}
+	quitHooks = nil
+	os.Stdout.Sync()
+	os.Stderr.Sync()
+}
+
+// fatalEvent returns an event logged at fatal level, without exiting as
+// log.Fatal does, for fail.
+func fatalEvent() *zerolog.Event {
+	return log.WithLevel(zerolog.FatalLevel)
+}
+
+// fail logs msg with event, and err if not nil, and returns the exit code
+// and the error Run returns when it can't start the main command.
+func fail(event *zerolog.Event, err error, msg string) (int, error) {
+	text := strings.ToLower(msg[:1]) + msg[1:]
+	if err == nil {
+		event.Msg(msg)
+		return 1, errors.New(text)
+	}
+	event.Err(err).Msg(msg)
+	return 1, fmt.Errorf("%s: %w", text, err)
+}
+
+// commandArgs returns the arguments to run command with, either through the
+// shell so pipes, redirects and expansions work, or split with parseArgs.
+func commandArgs(command string, useShell bool) []string {
+	if useShell {
+		return []string{shellPath(), "-c", command}
+	}
+	args, err := parseArgs(command)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot parse command, it is not run")
+	}
+	return args
+}
+
+// shellPath returns the shell to run commands with, honoring $SHELL.
+func shellPath() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "/bin/sh"
+}
+
+// parseArgs parses a command string into a slice of arguments, shell-style:
+// arguments are separated by blanks, single quotes group characters
+// literally, double quotes group characters with \ escaping only ", \, $
+// and `, and outside quotes \ escapes any character.
+func parseArgs(command string) ([]string, error) {
+	var args []string
+	var currentArg strings.Builder
+	inSingleQuotes := false
+	inDoubleQuotes := false
+	// inArg is set once the current argument started,
+	// so a quoted empty string is still an argument
+	inArg := false
+
+	for i := 0; i < len(command); i++ {
+		char := command[i]
+
+		switch {
+		case inSingleQuotes:
+			if char == '\'' {
+				inSingleQuotes = false
+			} else {
+				currentArg.WriteByte(char)
+			}
+		case inDoubleQuotes:
+			if char == '\\' && i+1 < len(command) && strings.IndexByte("\"\\$`", command[i+1]) >= 0 {
+				currentArg.WriteByte(command[i+1])
+				i++
+			} else if char == '"' {
+				inDoubleQuotes = false
+			} else {
+				currentArg.WriteByte(char)
+			}
+		case char == '\\' && i+1 < len(command):
+			currentArg.WriteByte(command[i+1])
+			inArg = true
+			i++
+		case char == '\'':
+			inSingleQuotes = true
+			inArg = true
+		case char == '"':
+			inDoubleQuotes = true
+			inArg = true
+		case char == ' ' || char == '\t' || char == '\n':
+			if inArg {
+				args = append(args, currentArg.String())
+				currentArg.Reset()
+				inArg = false
+			}
+		default:
+			currentArg.WriteByte(char)
+			inArg = true
+		}
+	}
+	if inSingleQuotes || inDoubleQuotes {
+		return nil, fmt.Errorf("unterminated quote in command %q", command)
+	}
+	// Blank or trailing spaces don't make an empty argument
+	if inArg {
+		args = append(args, currentArg.String())
+	}
+	return args, nil
+}
+
+// extractJSONKey parses payload as a JSON object and returns the value of key.
+// String values are returned as-is, any other value as its JSON encoding.
+func extractJSONKey(payload string, key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("no key specified, expected '<secret-name>#<key>'")
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object: %w", err)
+	}
+	raw, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in secret", key)
+	}
+	return jsonFieldValue(raw), nil
+}
+
+// extractJSONFields parses payload as a JSON object and returns the value
+// of each of its keys, like extractJSONKey.
+func extractJSONFields(payload string) (map[string]string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+		return nil, fmt.Errorf("secret is not a JSON object: %w", err)
+	}
+	values := make(map[string]string, len(fields))
+	for key, raw := range fields {
+		values[key] = jsonFieldValue(raw)
+	}
+	return values, nil
+}
+
+// jsonFieldValue returns a JSON string value as-is, and any other value
+// as its JSON encoding.
+func jsonFieldValue(raw json.RawMessage) string {
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return str
+	}
+	return string(raw)
+}
+
+// exitCode returns the exit code to propagate for an error returned by run,
+// using the conventional 128+signum when the command was killed by a signal.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if waitStatus, ok := waitStatusOf(err); ok {
+		if waitStatus.Signaled() {
+			return 128 + int(waitStatus.Signal())
+		}
+		return waitStatus.ExitStatus()
+	}
+	if isNotFound(err) {
+		return notFoundExitCode
+	}
+	if errors.Is(err, syscall.EACCES) {
+		return notExecutableExitCode
+	}
+	return 1 // The command could not be run at all
+}
+
+// isNotFound reports whether err is about a command missing from $PATH or
+// at the given path.
+func isNotFound(err error) bool {
+	return errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist)
+}
+
+// withExitReason adds how a command ended, given the error returned by run,
+// to event: reason is exited, signaled (with the signal name), timeout, or
+// error when the command could not be run at all.
+func withExitReason(event *zerolog.Event, err error) *zerolog.Event {
+	if err == nil {
+		return event.Str("reason", "exited")
+	}
+	if errors.Is(err, errTimeout) {
+		return event.Str("reason", "timeout")
+	}
+	if errors.Is(err, errOOMKilled) {
+		return event.Str("reason", "oom-killed")
+	}
+	if waitStatus, ok := waitStatusOf(err); ok {
+		if waitStatus.Signaled() {
+			return event.Str("reason", "signaled").Str("signal", signalName(waitStatus.Signal()))
+		}
+		return event.Str("reason", "exited")
+	}
+	return event.Str("reason", "error")
+}
+
+// isSuppressedError checks if the error indicates a termination that should suppress the "failed" message.
+func isSuppressedError(err error) bool {
+	if err == nil {
+		return true // Exited with status 0
+	}
+	if errors.Is(err, errOOMKilled) || errors.Is(err, errUnexpectedKill) {
+		return false // Never a clean SIGKILL
+	}
+	if waitStatus, ok := waitStatusOf(err); ok {
+		// Suppress for the clean exit signals, exit code 0 or the success codes
+		if _, success := successExitCodes[waitStatus.ExitStatus()]; success && waitStatus.Exited() {
+			return true
+		}
+		return waitStatus.Signaled() && cleanExitSignals[waitStatus.Signal()] || waitStatus.ExitStatus() == 0
+	}
+	return false // Any other error should not suppress "failed"
+}
+
+// successExitCode returns the exit code to propagate for an error returned
+// by run and suppressed by isSuppressedError, 0 unless a success code maps
+// the exit code of the command to another one.
+func successExitCode(err error) int {
+	if waitStatus, ok := waitStatusOf(err); ok && waitStatus.Exited() {
+		return successExitCodes[waitStatus.ExitStatus()]
+	}
+	return 0
+}
+
+// parseSuccessCodes parses a comma-separated list of exit codes, each
+// optionally followed by '=' and the exit code to propagate instead of 0,
+// returning nil for an empty list.
+func parseSuccessCodes(list string) (map[int]int, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	codes := make(map[int]int)
+	for _, item := range strings.Split(list, ",") {
+		codeSpec, mappedSpec, mapped := strings.Cut(strings.TrimSpace(item), "=")
+		code, err := strconv.Atoi(codeSpec)
+		if err != nil || code < 0 || code > 255 {
+			return nil, fmt.Errorf("invalid exit code %q", codeSpec)
+		}
+		codes[code] = 0
+		if mapped {
+			mappedCode, err := strconv.Atoi(mappedSpec)
+			if err != nil || mappedCode < 0 || mappedCode > 255 {
+				return nil, fmt.Errorf("invalid exit code %q", mappedSpec)
+			}
+			codes[code] = mappedCode
+		}
+	}
+	return codes, nil
+}
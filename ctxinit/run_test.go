@@ -0,0 +1,284 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testConfig returns the default config running args, without reaping so
+// the exit status of the other children of the test binary is not stolen.
+func testConfig(args ...string) Config {
+	cfg := DefaultConfig()
+	cfg.Command = args
+	cfg.NoReap = true
+	return cfg
+}
+
+func TestRunTTYCommandWithArgs(t *testing.T) {
+	cfg := testConfig("sh", "-c", "exit 0")
+	cfg.TTY = true
+	code, err := Run(context.Background(), cfg)
+	if err != nil || code != 0 {
+		t.Fatalf("Run() = %d, %v, want 0, nil", code, err)
+	}
+}
+
+func TestRunTTYSeveralCommands(t *testing.T) {
+	cfg := testConfig("sh", "-c", "exit 0")
+	cfg.RunCommands = []string{"true"}
+	cfg.TTY = true
+	if code, err := Run(context.Background(), cfg); err == nil || code != 1 {
+		t.Fatalf("Run() = %d, %v, want 1 and an error", code, err)
+	}
+}
+
+func TestRunBeforeExitOnFailure(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "before-exit")
+	t.Setenv("CTX_INIT_TEST_SECRET", "file:get:/nonexistent")
+	cfg := testConfig("true")
+	cfg.BeforeExit = "touch " + marker
+	if code, err := Run(context.Background(), cfg); err == nil || code != 1 {
+		t.Fatalf("Run() = %d, %v, want 1 and an error", code, err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("before-exit command did not run: %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"success", []string{"true"}, 0},
+		{"exit code", []string{"sh", "-c", "exit 7"}, 7},
+		{"not found", []string{"/nonexistent"}, notFoundExitCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := Run(context.Background(), testConfig(tt.args...))
+			if code != tt.want {
+				t.Errorf("Run() = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+	}{
+		{"no command", func(cfg *Config) { cfg.Command = nil }},
+		{"exit-on", func(cfg *Config) { cfg.ExitOn = "last" }},
+		{"restart", func(cfg *Config) { cfg.Restart = "sometimes" }},
+		{"secret concurrency", func(cfg *Config) { cfg.SecretConcurrency = 0 }},
+		{"nice", func(cfg *Config) { cfg.Nice = 20 }},
+		{"umask", func(cfg *Config) { cfg.Umask = "0999" }},
+		{"chdir", func(cfg *Config) { cfg.Chdir = "/nonexistent" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("true")
+			tt.modify(&cfg)
+			if code, err := Run(context.Background(), cfg); err == nil || code != 1 {
+				t.Errorf("Run() = %d, %v, want 1 and an error", code, err)
+			}
+		})
+	}
+}
+
+func TestRunContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	code, err := Run(ctx, testConfig("sleep", "10"))
+	// Killed by the forwarded SIGTERM, a clean exit signal
+	if err != nil || code != 0 {
+		t.Fatalf("Run() = %d, %v, want 0, nil", code, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run() returned after %s, want the command terminated", elapsed)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"  \t\n ", nil, false},
+		{"echo hello  world ", []string{"echo", "hello", "world"}, false},
+		{`echo 'a b' "c d"`, []string{"echo", "a b", "c d"}, false},
+		{`echo '' ""`, []string{"echo", "", ""}, false},
+		{`echo "it's" 'say "hi"'`, []string{"echo", "it's", `say "hi"`}, false},
+		{`echo a'b c'"d e"f`, []string{"echo", "ab cd ef"}, false},
+		{`echo "a \"b\" \\ \$HOME \n"`, []string{"echo", `a "b" \ $HOME \n`}, false},
+		{`echo 'a\b' a\ b \"`, []string{"echo", `a\b`, "a b", `"`}, false},
+		{`echo a\`, []string{"echo", `a\`}, false},
+		{`echo 'unterminated`, nil, true},
+		{`echo "unterminated`, nil, true},
+		{`echo "it's`, nil, true},
+		{`echo "a \"`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseArgs(tt.command)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseArgs(%q) error = %v, want error %t", tt.command, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("parseArgs(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestStartWait(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    int
+		wantErr error
+	}{
+		{"success", []string{"true"}, 0, nil},
+		{"exit code", []string{"sh", "-c", "exit 4"}, 4, nil},
+		{"killed by signal", []string{"sh", "-c", "kill -SEGV $$"}, 128 + int(syscall.SIGSEGV), nil},
+		{"unexpected SIGKILL", []string{"sh", "-c", "kill -KILL $$"}, 128 + int(syscall.SIGKILL), errUnexpectedKill},
+	}
+	// Left by the tests terminating Run
+	terminating.Store(false)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := start(tt.args, runOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = p.wait()
+			if code := exitCode(err); code != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", err, code, tt.want)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("wait() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStartNotFound(t *testing.T) {
+	_, err := start([]string{"/nonexistent"}, runOptions{})
+	if code := exitCode(err); code != notFoundExitCode {
+		t.Errorf("exitCode(%v) = %d, want %d", err, code, notFoundExitCode)
+	}
+}
+
+func TestStartTimeout(t *testing.T) {
+	p, err := start([]string{"sleep", "10"}, runOptions{timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.wait(); !errors.Is(err, errTimeout) {
+		t.Errorf("wait() = %v, want %v", err, errTimeout)
+	}
+}
+
+func TestRunUnparsableHook(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+	}{
+		{"pre", func(cfg *Config) { cfg.PreStart = "echo 'unterminated" }},
+		{"post", func(cfg *Config) { cfg.PostStop = "echo 'unterminated" }},
+		{"before-exit", func(cfg *Config) { cfg.BeforeExit = "echo 'unterminated" }},
+		{"post-start", func(cfg *Config) { cfg.PostStart = `echo "unterminated` }},
+		{"sidecar", func(cfg *Config) { cfg.Sidecar = `echo "unterminated` }},
+		{"pre-stop", func(cfg *Config) { cfg.PreStop = `echo "unterminated` }},
+		{"run", func(cfg *Config) { cfg.RunCommands = []string{"echo 'unterminated"} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("true")
+			tt.modify(&cfg)
+			if code, err := Run(context.Background(), cfg); err == nil || code != 1 {
+				t.Errorf("Run() = %d, %v, want 1 and an error", code, err)
+			}
+		})
+	}
+}
+
+func TestRunStartupFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+	}{
+		{"wait-for", func(cfg *Config) {
+			cfg.WaitFor = []string{"127.0.0.1:1"}
+			cfg.WaitTimeout = 100 * time.Millisecond
+		}},
+		{"pre-start", func(cfg *Config) { cfg.PreStart = "false" }},
+		{"sidecar", func(cfg *Config) { cfg.Sidecar = "/nonexistent" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("true")
+			tt.modify(&cfg)
+			if code, err := Run(context.Background(), cfg); err == nil || code != 1 {
+				t.Errorf("Run() = %d, %v, want 1 and an error", code, err)
+			}
+		})
+	}
+}
+
+func TestRunResetsState(t *testing.T) {
+	cfg := testConfig("/nonexistent")
+	cfg.CleanExitSignals = "SIGTERM"
+	cfg.LogSample = 10
+	cfg.DebugSignal = "SIGUSR2"
+	if code, _ := Run(context.Background(), cfg); code != notFoundExitCode {
+		t.Fatalf("Run() = %d, want %d", code, notFoundExitCode)
+	}
+
+	if code, err := Run(context.Background(), testConfig("true")); err != nil || code != 0 {
+		t.Fatalf("Run() = %d, %v, want 0, nil", code, err)
+	}
+	if len(phaseFailures.failures) > 0 {
+		t.Errorf("failures = %v, want none from the previous Run", phaseFailures.failures)
+	}
+	if !cleanExitSignals[syscall.SIGKILL] {
+		t.Errorf("cleanExitSignals = %v, want the defaults", cleanExitSignals)
+	}
+	if logSampler != nil {
+		t.Errorf("logSampler = %v, want none", logSampler)
+	}
+	if debugSignal != 0 {
+		t.Errorf("debugSignal = %s, want none", signalName(debugSignal))
+	}
+}
+
+func TestRunContextCancelledWaitFor(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	cfg := testConfig("true")
+	cfg.WaitFor = []string{"127.0.0.1:1"}
+	cfg.WaitTimeout = 0
+	start := time.Now()
+	if code, err := Run(ctx, cfg); err == nil || code != 1 {
+		t.Errorf("Run() = %d, %v, want 1 and an error", code, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run() returned after %s, want the wait interrupted", elapsed)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// secretFileMode is the mode of the files secrets are written to.
+const secretFileMode = 0600
+
+// Suffixes of the companion env vars setting the mode (octal, like 0440)
+// and the owner (user[:group], names or IDs) of the file an env var's
+// secret is written to.
+const (
+	secretFileModeSuffix  = "_MODE"
+	secretFileOwnerSuffix = "_OWNER"
+)
+
+// secretFileSettings returns the mode and owner of the secret file written
+// for env var envName from its companion env vars, secretFileMode and no
+// owner change by default.
+func secretFileSettings(envName string) (os.FileMode, *syscall.Credential, error) {
+	mode := os.FileMode(secretFileMode)
+	if modeSpec := os.Getenv(envName + secretFileModeSuffix); modeSpec != "" {
+		parsed, err := strconv.ParseUint(modeSpec, 8, 32)
+		if err != nil || parsed > 0777 {
+			return 0, nil, fmt.Errorf("%w: invalid %s%s %q, expected an octal mode like 0440", errMalformedRef, envName, secretFileModeSuffix, modeSpec)
+		}
+		mode = os.FileMode(parsed)
+	}
+	ownerSpec := os.Getenv(envName + secretFileOwnerSuffix)
+	if ownerSpec == "" {
+		return mode, nil, nil
+	}
+	userSpec, groupSpec, _ := strings.Cut(ownerSpec, ":")
+	owner, err := lookupCredential(userSpec, groupSpec)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: invalid %s%s %q: %w", errMalformedRef, envName, secretFileOwnerSuffix, ownerSpec, err)
+	}
+	return mode, owner, nil
+}
+
+// writeSecretFile atomically writes value to path with mode, owned by owner
+// if not nil, creating the parent directory if needed.
+func writeSecretFile(path string, value string, mode os.FileMode, owner *syscall.Credential) error {
+	return writeFileAtomicAs(path, value, mode, owner)
+}
+
+// writeFileAtomic writes value to path with mode, creating the parent
+// directory if needed. The value is written to a temporary file in the
+// same directory, then renamed over path, so readers never see a partial
+// content.
+func writeFileAtomic(path string, value string, mode os.FileMode) error {
+	return writeFileAtomicAs(path, value, mode, nil)
+}
+
+// writeFileAtomicAs is writeFileAtomic with the file owned by owner if not
+// nil, the owner being changed before the file is renamed over path.
+func writeFileAtomicAs(path string, value string, mode os.FileMode, owner *syscall.Credential) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath) // no-op once renamed
+
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		return err
+	}
+	if owner != nil {
+		if err := file.Chown(int(owner.Uid), int(owner.Gid)); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if _, err := file.WriteString(value); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// readSecretFile returns the content of the secret file at path, without
+// the trailing newline editors and most tools add.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(value, "\r"), nil
+}
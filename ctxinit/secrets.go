@@ -0,0 +1,783 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	gcpsecretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// secretPrefixes are the prefixes of all supported secret references.
+var secretPrefixes = []string{awsSecretsPrefix, awsParamsPrefix, gcpSecretsPrefix, vaultSecretsPrefix, azureSecretsPrefix, fileSecretsPrefix}
+
+// secretRefFields is the number of fields of the references of each prefix,
+// the last field taking the rest of the reference, separators included.
+var secretRefFields = map[string]int{
+	awsSecretsPrefix:   5,
+	awsParamsPrefix:    4,
+	gcpSecretsPrefix:   5,
+	vaultSecretsPrefix: 4,
+	azureSecretsPrefix: 4,
+	fileSecretsPrefix:  3,
+}
+
+// secretSeparator is the separator of the reference fields, set with
+// -secret-separator. References using the default separator are always
+// accepted.
+var secretSeparator = separator
+
+// secretTokenPattern matches ${<reference>} tokens embedded in a value.
+var secretTokenPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// errMalformedRef is returned for a secret reference missing some fields.
+var errMalformedRef = errors.New("malformed secret reference")
+
+// errSecretKey is returned when the key selected from a secret can't be extracted.
+var errSecretKey = errors.New("cannot extract key from secret")
+
+// errCircuitOpen is returned for the fetches not attempted once too many
+// consecutive fetches failed.
+var errCircuitOpen = errors.New("not fetched after too many consecutive secret fetch failures")
+
+// isUnusableRef reports whether err is about the reference itself rather
+// than fetching the secret, such references being ignored unless strict.
+func isUnusableRef(err error) bool {
+	return errors.Is(err, errMalformedRef) || errors.Is(err, errSecretKey)
+}
+
+// secretOptions holds the settings of secret resolution.
+type secretOptions struct {
+	// retries is the number of retries for transient fetch failures
+	retries int
+	// timeout, if not 0, bounds each fetch attempt
+	timeout time.Duration
+	// awsRegion overrides the region of the default AWS config chain
+	awsRegion string
+	// smEndpoint overrides the AWS Secrets Manager endpoint
+	smEndpoint string
+	// assumeRoleARN, if set, is the role assumed to fetch AWS secrets,
+	// with the given external ID (optional) and session name
+	assumeRoleARN         string
+	assumeRoleExternalID  string
+	assumeRoleSessionName string
+	// interpolate resolves ${<reference>} tokens anywhere in values
+	interpolate bool
+	// jsonEnvSkipExisting keeps the env vars colliding with jsonenv keys
+	jsonEnvSkipExisting bool
+	// listSecrets allows aws:sm:all: references, listing secrets by prefix
+	listSecrets bool
+	// optional downgrades secrets that can't be fetched to warnings
+	optional bool
+	// strict fails on unusable references instead of ignoring them
+	strict bool
+	// circuitBreaker, if not 0, is the number of consecutive fetch
+	// failures after which no more fetches are attempted
+	circuitBreaker int
+}
+
+// secretResolver resolves secret references, with a client for each
+// provider only created when the environment references it.
+type secretResolver struct {
+	opts             secretOptions
+	awsCredentials   aws.CredentialsProvider
+	secretsClient    *secretsmanager.Client
+	paramsClient     *ssm.Client
+	gcpSecretsClient *gcpsecretmanager.Client
+	vaultClient      *vault.Client
+	azureClients     *azureClients
+	// cache holds the payloads already fetched or being fetched, so each
+	// unique secret is fetched once even by concurrent workers
+	cacheMu sync.Mutex
+	cache   map[string]*cacheEntry
+	// consecutiveFailures counts the fetches failed since the last success
+	consecutiveFailures atomic.Int32
+}
+
+// cacheEntry is a secret payload, ready once done is closed.
+type cacheEntry struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+// resolveFailure is a secret reference of an env var that failed to resolve.
+type resolveFailure struct {
+	envName string
+	err     error
+}
+
+// canonicalRef returns value with the fields of a reference using
+// secretSeparator separated by the default separator instead.
+func canonicalRef(value string) string {
+	if secretSeparator == separator {
+		return value
+	}
+	for _, prefix := range secretPrefixes {
+		if strings.HasPrefix(value, strings.ReplaceAll(prefix, separator, secretSeparator)) {
+			return strings.Join(strings.SplitN(value, secretSeparator, secretRefFields[prefix]), separator)
+		}
+	}
+	return value
+}
+
+// refPrefix returns the secret prefix value starts with, or "" if none.
+func refPrefix(value string) string {
+	value = canonicalRef(value)
+	for _, prefix := range secretPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// secretRefs returns the secret references of value, either the whole
+// value or, when interpolating, the references of its ${...} tokens.
+func secretRefs(value string, interpolate bool) []string {
+	if refPrefix(value) != "" {
+		return []string{value}
+	}
+	if !interpolate {
+		return nil
+	}
+	var refs []string
+	for _, match := range secretTokenPattern.FindAllStringSubmatch(value, -1) {
+		if refPrefix(match[1]) != "" {
+			refs = append(refs, match[1])
+		}
+	}
+	return refs
+}
+
+// referencingEnvNames returns the sorted names of the env vars of envMap
+// referencing a secret with one of prefixes.
+func referencingEnvNames(envMap map[string]string, interpolate bool, prefixes ...string) []string {
+	var envNames []string
+	for envName, value := range envMap {
+		for _, ref := range secretRefs(value, interpolate) {
+			if slices.Contains(prefixes, refPrefix(ref)) {
+				envNames = append(envNames, envName)
+				break
+			}
+		}
+	}
+	sort.Strings(envNames)
+	return envNames
+}
+
+// referencedPrefixes returns the secret prefixes referenced by values.
+func referencedPrefixes(values map[string]string, interpolate bool) map[string]bool {
+	found := make(map[string]bool)
+	for _, value := range values {
+		for _, ref := range secretRefs(value, interpolate) {
+			found[refPrefix(ref)] = true
+		}
+	}
+	return found
+}
+
+// newSecretResolver creates the clients of the providers referenced in envMap.
+func newSecretResolver(ctx context.Context, envMap map[string]string, opts secretOptions) (*secretResolver, error) {
+	r := &secretResolver{opts: opts, cache: make(map[string]*cacheEntry)}
+	found := referencedPrefixes(envMap, opts.interpolate)
+
+	// Only load the AWS config if aws:sm: or aws:ssm: prefix is found
+	var awsCfg aws.Config
+	if found[awsSecretsPrefix] || found[awsParamsPrefix] {
+		var awsOpts []func(*config.LoadOptions) error
+		if opts.awsRegion != "" {
+			awsOpts = append(awsOpts, config.WithRegion(opts.awsRegion))
+		}
+		var err error
+		awsCfg, err = config.LoadDefaultConfig(ctx, awsOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the AWS configs: %w", err)
+		}
+		if opts.assumeRoleARN != "" {
+			// The default credentials are only used to assume the role
+			log.Debug().Str("roleARN", opts.assumeRoleARN).Msg("Assuming AWS role for secrets and parameters")
+			provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), opts.assumeRoleARN, func(o *stscreds.AssumeRoleOptions) {
+				o.RoleSessionName = opts.assumeRoleSessionName
+				if opts.assumeRoleExternalID != "" {
+					o.ExternalID = aws.String(opts.assumeRoleExternalID)
+				}
+			})
+			awsCfg.Credentials = aws.NewCredentialsCache(provider)
+		} else if os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "" {
+			// Each retrieval reads the token file again, so rotated tokens are used
+			log.Debug().Str("tokenFile", os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")).Msg("Using AWS web identity credentials")
+		}
+		r.awsCredentials = awsCfg.Credentials
+
+		// Fail early on missing credentials, each fetch would fail anyway
+		if _, err := awsCfg.Credentials.Retrieve(ctx); err != nil {
+			envNames := referencingEnvNames(envMap, opts.interpolate, awsSecretsPrefix, awsParamsPrefix)
+			if !opts.optional {
+				return nil, fmt.Errorf("no AWS credentials found for env vars %s, configure an IAM role (task role, IRSA, instance profile) or AWS_* credentials: %w", strings.Join(envNames, ", "), err)
+			}
+			log.Warn().Err(err).Strs("envVars", envNames).Msg("No AWS credentials found, the AWS secrets of these env vars will not be resolved")
+		}
+	}
+
+	// Only initialize Secrets Manager client if aws:sm: prefix is found
+	if found[awsSecretsPrefix] {
+		r.secretsClient = secretsmanager.NewFromConfig(awsCfg, func(o *secretsmanager.Options) {
+			// withRetries retries the fetches, not stacking on the SDK retries
+			o.Retryer = aws.NopRetryer{}
+			if opts.smEndpoint != "" {
+				log.Debug().Str("endpoint", opts.smEndpoint).Msg("Using custom AWS Secrets Manager endpoint")
+				o.BaseEndpoint = aws.String(opts.smEndpoint)
+			}
+		})
+	} else {
+		log.Debug().Msg("No environment variables with 'aws:sm:' prefix found, skipping AWS Secrets Manager setup.")
+	}
+
+	// Only initialize SSM client if aws:ssm: prefix is found
+	if found[awsParamsPrefix] {
+		r.paramsClient = ssm.NewFromConfig(awsCfg, func(o *ssm.Options) {
+			o.Retryer = aws.NopRetryer{}
+		})
+	} else {
+		log.Debug().Msg("No environment variables with 'aws:ssm:' prefix found, skipping AWS SSM Parameter Store setup.")
+	}
+
+	// Only initialize GCP Secret Manager client if gcp:sm: prefix is found
+	if found[gcpSecretsPrefix] {
+		client, err := gcpsecretmanager.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create the GCP Secret Manager client: %w", err)
+		}
+		r.gcpSecretsClient = client
+	} else {
+		log.Debug().Msg("No environment variables with 'gcp:sm:' prefix found, skipping GCP Secret Manager setup.")
+	}
+
+	// Only initialize Vault client if vault:kv: prefix is found
+	if found[vaultSecretsPrefix] {
+		client, err := newVaultClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create the Vault client: %w", err)
+		}
+		r.vaultClient = client
+	} else {
+		log.Debug().Msg("No environment variables with 'vault:kv:' prefix found, skipping Vault setup.")
+	}
+
+	// Only initialize Azure credential if azure:kv: prefix is found
+	if found[azureSecretsPrefix] {
+		credential, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create the Azure credential: %w", err)
+		}
+		r.azureClients = &azureClients{credential: credential}
+	} else {
+		log.Debug().Msg("No environment variables with 'azure:kv:' prefix found, skipping Azure Key Vault setup.")
+	}
+
+	return r, nil
+}
+
+// close releases the provider clients.
+func (r *secretResolver) close() {
+	if r.gcpSecretsClient != nil {
+		r.gcpSecretsClient.Close()
+	}
+}
+
+// resolveAll resolves the secret references of envMap with a pool of
+// concurrency workers, returning the env vars whose value changed, the
+// env vars referencing jsonenv secrets whose keys were set instead and are
+// to be unset, and every failure, sorted by env var name. The secrets listed
+// by aws:sm:all: references are set the same way as the jsonenv keys.
+func (r *secretResolver) resolveAll(ctx context.Context, envMap map[string]string, concurrency int) (map[string]string, []string, []resolveFailure) {
+	type job struct {
+		envName  string
+		envValue string
+	}
+	jobs := make(chan job)
+	resolved := make(map[string]string)
+	jsonEnvs := make(map[string]string)
+	var failures []resolveFailure
+	var mu sync.Mutex
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				value, changed, err := r.resolveEnv(ctx, j.envName, j.envValue)
+				mu.Lock()
+				if err != nil {
+					failures = append(failures, resolveFailure{envName: j.envName, err: err})
+				} else if changed && isJSONEnvRef(j.envValue) {
+					jsonEnvs[j.envName] = value
+				} else if changed {
+					resolved[j.envName] = value
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for envName, envValue := range envMap {
+		jobs <- job{envName: envName, envValue: envValue}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Set each key of the jsonenv secrets as its own env var, in order
+	// so colliding keys are handled the same way on every run
+	exploded := make([]string, 0, len(jsonEnvs))
+	for envName := range jsonEnvs {
+		exploded = append(exploded, envName)
+	}
+	sort.Strings(exploded)
+	for _, envName := range exploded {
+		fields, err := extractJSONFields(jsonEnvs[envName])
+		if err != nil {
+			failures = append(failures, resolveFailure{envName: envName, err: err})
+			continue
+		}
+		for key, value := range fields {
+			secretRedactor.add(value)
+			_, exists := envMap[key]
+			if _, set := resolved[key]; exists || set {
+				if r.opts.jsonEnvSkipExisting {
+					log.Warn().Str("envVar", envName).Str("key", key).Msg("Key of JSON secret collides with an existing env var, skipping it")
+					continue
+				}
+				log.Warn().Str("envVar", envName).Str("key", key).Msg("Key of JSON secret collides with an existing env var, overriding it")
+			}
+			resolved[key] = value
+		}
+	}
+
+	sort.Slice(failures, func(i, j int) bool { return failures[i].envName < failures[j].envName })
+	return resolved, exploded, failures
+}
+
+// isJSONEnvRef reports whether value references a secret whose keys are
+// each set as an env var, with the aws:sm:jsonenv: or aws:sm:all: format.
+func isJSONEnvRef(value string) bool {
+	value = canonicalRef(value)
+	return strings.HasPrefix(value, awsSecretsPrefix+"jsonenv"+separator) || strings.HasPrefix(value, awsSecretsPrefix+"all"+separator)
+}
+
+// fetchAWSSecretsUnder fetches every secret whose name starts with prefix,
+// returning them as a JSON object keyed by their env var names.
+func (r *secretResolver) fetchAWSSecretsUnder(ctx context.Context, envName string, prefix string) (string, error) {
+	if !r.opts.listSecrets {
+		return "", fmt.Errorf("secrets under %q: aws:sm:all: references require -aws-sm-all", prefix)
+	}
+	if prefix == "" {
+		return "", fmt.Errorf("%w: expected 'aws:sm:all:<action>:<name-prefix>'", errMalformedRef)
+	}
+	names, err := withFreshAWSCredentials(ctx, r.awsCredentials, func(ctx context.Context) ([]string, error) {
+		return listAWSSecretNames(ctx, r.secretsClient, prefix, r.opts.retries, r.opts.timeout)
+	})
+	if err != nil {
+		return "", fmt.Errorf("secrets under %q: cannot list them: %w", prefix, err)
+	}
+	sort.Strings(names)
+	log.Debug().Str("envVar", envName).Str("prefix", prefix).Int("count", len(names)).Msg("Listed secrets for env var")
+
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		key := awsSecretEnvName(name)
+		if _, exists := values[key]; exists {
+			log.Warn().Str("envVar", envName).Str("name", name).Str("key", key).Msg("Listed secret has the same env var name as another one, skipping it")
+			continue
+		}
+		value, _, err := r.fetchCached(ctx, awsSecretsPrefix+name, func(ctx context.Context) (string, error) {
+			result, err := withFreshAWSCredentials(ctx, r.awsCredentials, func(ctx context.Context) (*secretsmanager.GetSecretValueOutput, error) {
+				return getAWSSecretValue(ctx, r.secretsClient, &secretsmanager.GetSecretValueInput{SecretId: aws.String(name)}, r.opts.retries, r.opts.timeout)
+			})
+			if err != nil {
+				return "", err
+			}
+			return awsSecretPayload(result, false), nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("secret %q: %w", name, err)
+		}
+		values[key] = value
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// fetchCached returns the payload cached under key, calling fetch to get it
+// if no other reference fetched it yet, and whether it came from the cache.
+// Each fetch is traced as a span named after key, never holding the payload.
+func (r *secretResolver) fetchCached(ctx context.Context, key string, fetch func(ctx context.Context) (string, error)) (string, bool, error) {
+	r.cacheMu.Lock()
+	if entry, ok := r.cache[key]; ok {
+		r.cacheMu.Unlock()
+		<-entry.done
+		return entry.value, true, entry.err
+	}
+	entry := &cacheEntry{done: make(chan struct{})}
+	r.cache[key] = entry
+	r.cacheMu.Unlock()
+
+	breaker := int32(r.opts.circuitBreaker)
+	if breaker > 0 && r.consecutiveFailures.Load() >= breaker {
+		// The backend is assumed down, fail fast instead of retrying
+		entry.err = errCircuitOpen
+		close(entry.done)
+		return entry.value, false, entry.err
+	}
+	ctx, span := tracer.Start(ctx, "fetch secret", trace.WithAttributes(attribute.String("secret.name", key)))
+	entry.value, entry.err = fetch(ctx)
+	endSpan(span, entry.err)
+	metrics.secretFetches.Add(1)
+	if entry.err == nil {
+		secretRedactor.add(entry.value)
+		r.consecutiveFailures.Store(0)
+	} else {
+		metrics.secretFetchFailures.Add(1)
+		if r.consecutiveFailures.Add(1) == breaker {
+			log.Error().Int("failures", int(breaker)).Msg("Too many consecutive secret fetch failures, not fetching the remaining secrets")
+		}
+	}
+	close(entry.done)
+	return entry.value, false, entry.err
+}
+
+// resolveEnv returns the value of env var envName with its secret references
+// resolved, and whether it changed. References that are malformed or whose
+// key can't be extracted are logged and left in place.
+func (r *secretResolver) resolveEnv(ctx context.Context, envName string, envValue string) (string, bool, error) {
+	if refPrefix(envValue) != "" {
+		value, err := r.resolve(ctx, envName, envValue)
+		if isUnusableRef(err) && !r.opts.strict {
+			log.Warn().Err(err).Str("envVar", envName).Msg("Ignoring environment variable with unusable secret reference")
+			return envValue, false, nil
+		}
+		if err != nil {
+			return envValue, false, err
+		}
+		return value, true, nil
+	}
+	if !r.opts.interpolate {
+		return envValue, false, nil
+	}
+
+	// Substitute each ${<reference>} token inline
+	var resolveErr error
+	changed := false
+	value := secretTokenPattern.ReplaceAllStringFunc(envValue, func(token string) string {
+		ref := token[2 : len(token)-1]
+		if resolveErr != nil || refPrefix(ref) == "" {
+			return token
+		}
+		secretValue, err := r.resolve(ctx, envName, ref)
+		if isUnusableRef(err) && !r.opts.strict {
+			log.Warn().Err(err).Str("envVar", envName).Msg("Ignoring unusable secret reference in environment variable")
+			return token
+		}
+		if err != nil {
+			resolveErr = err
+			return token
+		}
+		changed = true
+		return secretValue
+	})
+	if resolveErr != nil {
+		return envValue, false, resolveErr
+	}
+	return value, changed, nil
+}
+
+// resolveArgs resolves the secret references of command arguments like
+// env var values, returning the resolved arguments and whether any changed.
+func (r *secretResolver) resolveArgs(ctx context.Context, args []string) ([]string, bool, error) {
+	resolved := make([]string, len(args))
+	changed := false
+	for i, arg := range args {
+		value, argChanged, err := r.resolveEnv(ctx, fmt.Sprintf("argv[%d]", i), arg)
+		if err != nil {
+			return nil, false, fmt.Errorf("argv[%d]: %w", i, err)
+		}
+		resolved[i] = value
+		changed = changed || argChanged
+	}
+	return resolved, changed, nil
+}
+
+// resolve fetches the secret value of a single reference, falling back to
+// the default after a '|' when the secret can't be fetched and secrets are
+// optional.
+func (r *secretResolver) resolve(ctx context.Context, envName string, ref string) (string, error) {
+	ref, defaultValue, hasDefault := strings.Cut(ref, "|")
+	value, err := r.fetchRef(ctx, envName, ref)
+	if err != nil && hasDefault && r.opts.optional && !isUnusableRef(err) {
+		log.Warn().Err(err).Str("envVar", envName).Msg("Failed to retrieve secret for env var, using its default")
+		return defaultValue, nil
+	}
+	return value, err
+}
+
+// fetchRef fetches the secret value of a single reference. $VAR and ${VAR}
+// references in the secret names and paths are expanded from the environment.
+func (r *secretResolver) fetchRef(ctx context.Context, envName string, ref string) (string, error) {
+	ref = canonicalRef(ref)
+	switch refPrefix(ref) {
+	case awsSecretsPrefix:
+		parts := strings.SplitN(ref, separator, 5)
+		if len(parts) != 5 { // check for correct number of parts
+			return "", fmt.Errorf("%w: expected 'aws:sm:<format>:<action>:<secret-name>'", errMalformedRef)
+		}
+		provider := parts[0]
+		service := parts[1]
+		format := parts[2]
+		action := parts[3]
+		secretName := parts[4]
+		secretKey := ""
+		filePath := ""
+		switch format {
+		case "json":
+			secretName, secretKey, _ = strings.Cut(secretName, "#")
+		case "file":
+			secretName, filePath, _ = strings.Cut(secretName, "#")
+			if filePath == "" {
+				return "", fmt.Errorf("%w: expected 'aws:sm:file:<action>:<secret-name>#<path>'", errMalformedRef)
+			}
+		}
+		secretName = os.ExpandEnv(secretName)
+		log.Debug().Str("envVar", envName).Str("provider", provider).Str("service", service).Str("type", format).Str("action", action).Str("name", secretName).Str("key", secretKey).Msg("Attempting to retrieve secret for env var")
+		if format == "all" {
+			return r.fetchAWSSecretsUnder(ctx, envName, secretName)
+		}
+
+		// Binary secrets are base64-encoded, unless their raw bytes are
+		// wanted by the bin format or to extract a JSON key
+		raw := format == "bin" || format == "json" || format == "file" || format == "jsonenv"
+		cacheKey := awsSecretsPrefix + secretName
+		if raw {
+			cacheKey = awsSecretsPrefix + "bin" + separator + secretName
+		}
+		secretValue, cached, err := r.fetchCached(ctx, cacheKey, func(ctx context.Context) (string, error) {
+			result, err := withFreshAWSCredentials(ctx, r.awsCredentials, func(ctx context.Context) (*secretsmanager.GetSecretValueOutput, error) {
+				return getAWSSecretValue(ctx, r.secretsClient, awsSecretValueInput(secretName), r.opts.retries, r.opts.timeout)
+			})
+			if err != nil {
+				return "", err
+			}
+			return awsSecretPayload(result, raw), nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("secret %q: %w", secretName, err)
+		}
+		if cached {
+			log.Debug().Str("envVar", envName).Str("name", secretName).Msg("Using cached secret for env var")
+		}
+
+		if format == "json" {
+			value, err := extractJSONKey(secretValue, secretKey)
+			if err != nil {
+				return "", fmt.Errorf("%w %q: %w", errSecretKey, secretName, err)
+			}
+			secretRedactor.add(value)
+			return value, nil
+		}
+		if format == "file" {
+			// Deliver the secret through a file, keeping it out of the environment
+			mode, owner, err := secretFileSettings(envName)
+			if err != nil {
+				return "", err
+			}
+			if owner != nil && os.Geteuid() != 0 {
+				log.Warn().Str("envVar", envName).Str("path", filePath).Msg("Not running as root, cannot change the owner of the secret file")
+				owner = nil
+			}
+			if err := writeSecretFile(filePath, secretValue, mode, owner); err != nil {
+				return "", fmt.Errorf("secret %q: cannot write file: %w", secretName, err)
+			}
+			log.Debug().Str("envVar", envName).Str("name", secretName).Str("path", filePath).Msg("Wrote secret to file for env var")
+			return filePath, nil
+		}
+		if format == "bin" && strings.ContainsRune(secretValue, 0) {
+			return "", fmt.Errorf("secret %q: raw value contains NUL bytes and cannot be set in an env var", secretName)
+		}
+		return secretValue, nil
+
+	case awsParamsPrefix:
+		parts := strings.SplitN(ref, separator, 4)
+		if len(parts) != 4 { // check for correct number of parts
+			return "", fmt.Errorf("%w: expected 'aws:ssm:<action>:<parameter-name>'", errMalformedRef)
+		}
+		provider := parts[0]
+		service := parts[1]
+		action := parts[2]
+		paramName := os.ExpandEnv(parts[3])
+		log.Debug().Str("envVar", envName).Str("provider", provider).Str("service", service).Str("action", action).Str("name", paramName).Msg("Attempting to retrieve parameter for env var")
+
+		paramValue, cached, err := r.fetchCached(ctx, awsParamsPrefix+paramName, func(ctx context.Context) (string, error) {
+			getParameterInput := &ssm.GetParameterInput{
+				Name:           aws.String(paramName),
+				WithDecryption: aws.Bool(true),
+			}
+			result, err := withFreshAWSCredentials(ctx, r.awsCredentials, func(ctx context.Context) (*ssm.GetParameterOutput, error) {
+				return getAWSParameter(ctx, r.paramsClient, getParameterInput, r.opts.retries, r.opts.timeout)
+			})
+			if err != nil {
+				return "", err
+			}
+			return aws.ToString(result.Parameter.Value), nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("parameter %q: %w", paramName, err)
+		}
+		if cached {
+			log.Debug().Str("envVar", envName).Str("name", paramName).Msg("Using cached parameter for env var")
+		}
+		return paramValue, nil
+
+	case gcpSecretsPrefix:
+		parts := strings.SplitN(ref, separator, 5)
+		if len(parts) != 5 { // check for correct number of parts
+			return "", fmt.Errorf("%w: expected 'gcp:sm:<project>:<action>:<secret-name>'", errMalformedRef)
+		}
+		provider := parts[0]
+		service := parts[1]
+		project := os.ExpandEnv(parts[2])
+		action := parts[3]
+		secretName := os.ExpandEnv(parts[4])
+		log.Debug().Str("envVar", envName).Str("provider", provider).Str("service", service).Str("project", project).Str("action", action).Str("name", secretName).Msg("Attempting to retrieve secret for env var")
+
+		secretVersion := "projects/" + project + "/secrets/" + secretName + "/versions/latest"
+		secretValue, cached, err := r.fetchCached(ctx, gcpSecretsPrefix+secretVersion, func(ctx context.Context) (string, error) {
+			accessSecretVersionReq := &secretmanagerpb.AccessSecretVersionRequest{
+				Name: secretVersion,
+			}
+			ctx, cancel := withSecretTimeout(ctx, r.opts.timeout)
+			defer cancel()
+			result, err := r.gcpSecretsClient.AccessSecretVersion(ctx, accessSecretVersionReq)
+			if err != nil {
+				return "", err
+			}
+			return string(result.Payload.Data), nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("secret %q: %w", secretName, err)
+		}
+		if cached {
+			log.Debug().Str("envVar", envName).Str("name", secretName).Msg("Using cached secret for env var")
+		}
+		return secretValue, nil
+
+	case vaultSecretsPrefix:
+		parts := strings.SplitN(ref, separator, 4)
+		if len(parts) != 4 { // check for correct number of parts
+			return "", fmt.Errorf("%w: expected 'vault:kv:<action>:<path>#<key>'", errMalformedRef)
+		}
+		provider := parts[0]
+		service := parts[1]
+		action := parts[2]
+		secretPath, secretKey, _ := strings.Cut(parts[3], "#")
+		secretPath = os.ExpandEnv(secretPath)
+		log.Debug().Str("envVar", envName).Str("provider", provider).Str("service", service).Str("action", action).Str("name", secretPath).Str("key", secretKey).Msg("Attempting to retrieve secret for env var")
+
+		secretData, cached, err := r.fetchCached(ctx, vaultSecretsPrefix+secretPath, func(ctx context.Context) (string, error) {
+			ctx, cancel := withSecretTimeout(ctx, r.opts.timeout)
+			defer cancel()
+			return readVaultSecret(ctx, r.vaultClient, secretPath)
+		})
+		if err != nil {
+			return "", fmt.Errorf("secret %q: %w", secretPath, err)
+		}
+		if cached {
+			log.Debug().Str("envVar", envName).Str("name", secretPath).Msg("Using cached secret for env var")
+		}
+		value, err := extractJSONKey(secretData, secretKey)
+		if err != nil {
+			return "", fmt.Errorf("%w %q: %w", errSecretKey, secretPath, err)
+		}
+		secretRedactor.add(value)
+		return value, nil
+
+	case azureSecretsPrefix:
+		parts := strings.SplitN(ref, separator, 4)
+		if len(parts) != 4 { // check for correct number of parts
+			return "", fmt.Errorf("%w: expected 'azure:kv:<action>:<vault-url>#<secret-name>'", errMalformedRef)
+		}
+		provider := parts[0]
+		service := parts[1]
+		action := parts[2]
+		vaultURL, secretName, _ := strings.Cut(parts[3], "#")
+		if vaultURL == "" || secretName == "" {
+			return "", fmt.Errorf("%w: expected 'azure:kv:<action>:<vault-url>#<secret-name>'", errMalformedRef)
+		}
+		vaultURL, secretName = os.ExpandEnv(vaultURL), os.ExpandEnv(secretName)
+		log.Debug().Str("envVar", envName).Str("provider", provider).Str("service", service).Str("action", action).Str("vault", vaultURL).Str("name", secretName).Msg("Attempting to retrieve secret for env var")
+
+		secretValue, cached, err := r.fetchCached(ctx, azureSecretsPrefix+vaultURL+"#"+secretName, func(ctx context.Context) (string, error) {
+			ctx, cancel := withSecretTimeout(ctx, r.opts.timeout)
+			defer cancel()
+			return r.azureClients.readAzureSecret(ctx, vaultURL, secretName)
+		})
+		if err != nil {
+			return "", fmt.Errorf("secret %q: %w", secretName, err)
+		}
+		if cached {
+			log.Debug().Str("envVar", envName).Str("name", secretName).Msg("Using cached secret for env var")
+		}
+		return secretValue, nil
+
+	case fileSecretsPrefix:
+		parts := strings.SplitN(ref, separator, 3)
+		if len(parts) != 3 || parts[1] != "get" || parts[2] == "" { // check for correct number of parts
+			return "", fmt.Errorf("%w: expected 'file:get:<path>'", errMalformedRef)
+		}
+		provider := parts[0]
+		action := parts[1]
+		filePath := os.ExpandEnv(parts[2])
+		log.Debug().Str("envVar", envName).Str("provider", provider).Str("action", action).Str("path", filePath).Msg("Attempting to retrieve secret for env var")
+
+		secretValue, cached, err := r.fetchCached(ctx, fileSecretsPrefix+filePath, func(ctx context.Context) (string, error) {
+			return readSecretFile(filePath)
+		})
+		if err != nil {
+			return "", fmt.Errorf("secret file %q: %w", filePath, err)
+		}
+		if cached {
+			log.Debug().Str("envVar", envName).Str("path", filePath).Msg("Using cached secret for env var")
+		}
+		return secretValue, nil
+	}
+	return "", fmt.Errorf("%w: unknown provider", errMalformedRef)
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileRefs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	envMap := map[string]string{
+		"DB_PASS":                "file:get:" + path,
+		"SPRING_CONFIG_IMPORT":   "file:///tmp/app.yml",
+		"SPRING_CONFIG_LOCATION": "file:./config/",
+		"OTHER_ACTION":           "file:list:/tmp",
+	}
+	r, err := newSecretResolver(context.Background(), envMap, secretOptions{strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+
+	resolved, _, failures := r.resolveAll(context.Background(), envMap, 1)
+	if len(failures) > 0 {
+		t.Fatalf("resolveAll() failures = %v, want none", failures)
+	}
+	want := map[string]string{"DB_PASS": "s3cret"}
+	if len(resolved) != len(want) || resolved["DB_PASS"] != want["DB_PASS"] {
+		t.Errorf("resolveAll() = %v, want %v", resolved, want)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// signalNames maps the names accepted in signal flags to their signal.
+var signalNames = map[string]syscall.Signal{
+	"SIGABRT":   syscall.SIGABRT,
+	"SIGALRM":   syscall.SIGALRM,
+	"SIGBUS":    syscall.SIGBUS,
+	"SIGCHLD":   syscall.SIGCHLD,
+	"SIGCONT":   syscall.SIGCONT,
+	"SIGFPE":    syscall.SIGFPE,
+	"SIGHUP":    syscall.SIGHUP,
+	"SIGILL":    syscall.SIGILL,
+	"SIGINT":    syscall.SIGINT,
+	"SIGIO":     syscall.SIGIO,
+	"SIGKILL":   syscall.SIGKILL,
+	"SIGPIPE":   syscall.SIGPIPE,
+	"SIGPROF":   syscall.SIGPROF,
+	"SIGQUIT":   syscall.SIGQUIT,
+	"SIGSEGV":   syscall.SIGSEGV,
+	"SIGSTOP":   syscall.SIGSTOP,
+	"SIGSYS":    syscall.SIGSYS,
+	"SIGTERM":   syscall.SIGTERM,
+	"SIGTRAP":   syscall.SIGTRAP,
+	"SIGTSTP":   syscall.SIGTSTP,
+	"SIGTTIN":   syscall.SIGTTIN,
+	"SIGTTOU":   syscall.SIGTTOU,
+	"SIGURG":    syscall.SIGURG,
+	"SIGUSR1":   syscall.SIGUSR1,
+	"SIGUSR2":   syscall.SIGUSR2,
+	"SIGVTALRM": syscall.SIGVTALRM,
+	"SIGWINCH":  syscall.SIGWINCH,
+	"SIGXCPU":   syscall.SIGXCPU,
+	"SIGXFSZ":   syscall.SIGXFSZ,
+}
+
+// parseSignal parses a signal name like SIGUSR1, USR1 or a signal number.
+func parseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if number, err := strconv.Atoi(name); err == nil && number > 0 {
+		return syscall.Signal(number), nil
+	}
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig, ok := signalNames[name]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", name)
+}
+
+// signalName returns the name of sig, like SIGKILL, or its number if the
+// signal has no portable name.
+func signalName(sig syscall.Signal) string {
+	for name, named := range signalNames {
+		if named == sig {
+			return name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// parseSignalList parses a comma-separated list of signals into a set,
+// returning nil for an empty list.
+func parseSignalList(list string) (map[syscall.Signal]bool, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	set := make(map[syscall.Signal]bool)
+	for _, name := range strings.Split(list, ",") {
+		sig, err := parseSignal(name)
+		if err != nil {
+			return nil, err
+		}
+		set[sig] = true
+	}
+	return set, nil
+}
+
+// parseSignalRemaps parses <signal>=<signal> pairs into the signal each
+// received signal is forwarded as, returning nil for no pairs.
+func parseSignalRemaps(pairs []string) (map[syscall.Signal]syscall.Signal, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	remaps := make(map[syscall.Signal]syscall.Signal, len(pairs))
+	for _, pair := range pairs {
+		from, to, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("missing '=' in %q", pair)
+		}
+		fromSig, err := parseSignal(from)
+		if err != nil {
+			return nil, err
+		}
+		toSig, err := parseSignal(to)
+		if err != nil {
+			return nil, err
+		}
+		remaps[fromSig] = toSig
+	}
+	return remaps, nil
+}
+
+// shouldForward reports whether sig is forwarded to the running command.
+// SIGCHLD and the debug signal are only useful to ctx-init, so they are
+// never forwarded, like SIGHUP with -reload-on-hup, and nothing is with
+// -no-forward. SIGPIPE is about a write of ctx-init itself to a closed
+// pipe and would kill the whole process group, so it is only forwarded
+// when explicitly listed in -forward-signals.
+func shouldForward(sig syscall.Signal) bool {
+	if noForward || (reloadOnHUP && sig == syscall.SIGHUP) || sig == syscall.SIGCHLD || (debugSignal != 0 && sig == debugSignal) || ignoredSignals[sig] {
+		return false
+	}
+	if sig == syscall.SIGPIPE {
+		return forwardedSignals[sig]
+	}
+	return forwardedSignals == nil || forwardedSignals[sig]
+}
+
+// handleDebugSignal writes the stacks of all goroutines to stderr each time
+// sig is received, to diagnose ctx-init itself, until the returned function
+// is called.
+func handleDebugSignal(sig syscall.Signal) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, sig)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1<<20)
+		for range sigs {
+			n := runtime.Stack(buf, true)
+			fmt.Fprintf(os.Stderr, "=== ctx-init goroutine dump ===\n%s=== end of goroutine dump ===\n", buf[:n])
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(sigs)
+		<-done
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingShutdownTimeout bounds the flush of the pending spans on exit.
+const tracingShutdownTimeout = 5 * time.Second
+
+// tracer creates the spans of the startup phases. It is a no-op unless
+// setupTracing installed an exporter.
+var tracer = otel.Tracer("github.com/liifi/ctx-init")
+
+// setupTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT
+// or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set, configured by the standard
+// OTEL_* env vars. The pending spans are flushed once Run returns.
+func setupTracing() {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return
+	}
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		log.Warn().Err(err).Msg("Cannot set up the OTLP trace exporter, tracing disabled")
+		return
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+	onQuit(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Warn().Err(err).Msg("Cannot flush the pending spans")
+		}
+	})
+	log.Debug().Msg("Tracing enabled")
+}
+
+// endSpan ends span, marking it as failed with err if not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedRun runs the command of args like run, traced as a span named name.
+func tracedRun(ctx context.Context, name string, args []string, opts runOptions) error {
+	_, span := tracer.Start(ctx, name)
+	err := run(args, opts)
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	vault "github.com/hashicorp/vault/api"
+	vaultauth "github.com/hashicorp/vault/api/auth/kubernetes"
+	"github.com/rs/zerolog/log"
+)
+
+// newVaultClient builds a Vault client from the standard VAULT_* env vars.
+// When VAULT_TOKEN is not set it falls back to Kubernetes auth, using the
+// role in VAULT_K8S_ROLE and the mount in VAULT_K8S_MOUNT (default "kubernetes").
+func newVaultClient(ctx context.Context) (*vault.Client, error) {
+	// DefaultConfig reads VAULT_ADDR, NewClient reads VAULT_TOKEN
+	client, err := vault.NewClient(vault.DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+	if client.Token() != "" {
+		log.Debug().Msg("Using VAULT_TOKEN for Vault authentication")
+		return client, nil
+	}
+
+	role := os.Getenv("VAULT_K8S_ROLE")
+	if role == "" {
+		return nil, fmt.Errorf("neither VAULT_TOKEN nor VAULT_K8S_ROLE is set")
+	}
+	var opts []vaultauth.LoginOption
+	if mount := os.Getenv("VAULT_K8S_MOUNT"); mount != "" {
+		opts = append(opts, vaultauth.WithMountPath(mount))
+	}
+	k8sAuth, err := vaultauth.NewKubernetesAuth(role, opts...)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Str("role", role).Msg("Using Kubernetes auth for Vault authentication")
+	authInfo, err := client.Auth().Login(ctx, k8sAuth)
+	if err != nil {
+		return nil, err
+	}
+	if authInfo == nil {
+		return nil, fmt.Errorf("no auth info returned from Kubernetes login")
+	}
+	return client, nil
+}
+
+// readVaultSecret reads a KV secret at path and returns its fields as a JSON
+// object. KV v2 responses nest the fields under "data", KV v1 responses
+// return them directly.
+func readVaultSecret(ctx context.Context, client *vault.Client, path string) (string, error) {
+	secret, err := client.Logical().ReadWithContext(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("no secret found at %q", path)
+	}
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return string(payload), nil
+}
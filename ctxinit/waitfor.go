@@ -0,0 +1,148 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package ctxinit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// waitForRetryDelay is the delay between two dials of a -wait-for target.
+const waitForRetryDelay = time.Second
+
+// waitForDialTimeout is the timeout of each dial of a -wait-for target.
+const waitForDialTimeout = time.Second
+
+// waitForHTTPTimeout is the timeout of each request to a -wait-for-http URL.
+const waitForHTTPTimeout = 5 * time.Second
+
+// waitForTargets dials each host:port target over TCP until it accepts a
+// connection, then requests each of urls until it answers with a 2xx or
+// 3xx status, in order, failing once timeout elapses (0 waits forever) or
+// ctx is done.
+func waitForTargets(ctx context.Context, targets []string, urls []string, timeout time.Duration) error {
+	for _, target := range targets {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return fmt.Errorf("invalid target %q: %w", target, err)
+		}
+	}
+	for _, rawURL := range urls {
+		if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid target %q: expected an http:// or https:// URL", rawURL)
+		}
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for _, target := range targets {
+		if err := waitForTarget(ctx, target, deadline, timeout, dialAny); err != nil {
+			return err
+		}
+	}
+	client := &http.Client{
+		Timeout: waitForHTTPTimeout,
+		// A redirect already tells the service is up
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	for _, rawURL := range urls {
+		err := waitForTarget(ctx, rawURL, deadline, timeout, func(ctx context.Context, target string) error {
+			return checkHTTP(ctx, client, target)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForTarget calls check on target until it succeeds, failing once the
+// deadline of timeout is reached (zero waits forever) or ctx is done.
+func waitForTarget(ctx context.Context, target string, deadline time.Time, timeout time.Duration, check func(ctx context.Context, target string) error) error {
+	for attempt := 1; ; attempt++ {
+		err := check(ctx, target)
+		if err == nil {
+			log.Debug().Str("target", target).Int("attempt", attempt).Msg("Wait-for target is reachable")
+			return nil
+		}
+		delay := waitForRetryDelay
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return fmt.Errorf("target %q not reachable after %s: %w", target, timeout, err)
+			}
+			delay = min(delay, remaining)
+		}
+		log.Debug().Err(err).Str("target", target).Int("attempt", attempt).Msg("Wait-for target not reachable yet, retrying")
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("stopped waiting for target %q: %w", target, context.Cause(ctx))
+		}
+	}
+}
+
+// dialAny dials each address the host of the host:port target resolves
+// to concurrently, each with the whole dial timeout, succeeding as soon as
+// one accepts a connection. IPv6 literals are written like [::1]:5432.
+func dialAny(ctx context.Context, target string) error {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, waitForDialTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return err
+	}
+
+	errs := make(chan error, len(addrs))
+	for _, addr := range addrs {
+		go func() {
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr, port))
+			if err == nil {
+				conn.Close()
+			}
+			errs <- err
+		}()
+	}
+	var dialErrs []error
+	for range addrs {
+		err := <-errs
+		if err == nil {
+			return nil
+		}
+		dialErrs = append(dialErrs, err)
+	}
+	return errors.Join(dialErrs...)
+}
+
+// checkHTTP requests target with GET, succeeding on a 2xx or 3xx status.
+func checkHTTP(ctx context.Context, client *http.Client, target string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -12,323 +12,147 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
-	"os/signal"
 	"strings"
-	"sync"
-	"syscall"
-	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
-
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/rs/zerolog"
+	"github.com/liifi/ctx-init/ctxinit"
 	"github.com/rs/zerolog/log"
 )
 
+// Build metadata, set with -ldflags "-X main.<name>=<value>".
 var (
 	versionString = "undefined"
+	commit        = "undefined"
+	buildDate     = "undefined"
 )
 
-const separator = ":"
-const awsSecretsPrefix = "aws" + separator + "sm" + separator
-const component = "ctx-init"
-
-var logger zerolog.Logger
-
 func main() {
-	var preStartCmd string
-	var postStopCmd string
+	var configFile string
 	var version bool
-
-	flag.StringVar(&preStartCmd, "pre", "", "Pre-start command")
-	flag.StringVar(&postStopCmd, "post", "", "Post-stop command")
+	var logFile string
+	var logOnlyFile bool
+	var quiet bool
+
+	// Each flag sets the setting of the same name of the config of ctxinit.Run
+	defaults := ctxinit.DefaultConfig()
+	cfg := defaults
+	flag.StringVar(&configFile, "config", "", "YAML file of settings named like the flags, and the main command under 'command'")
+	flag.StringVar(&cfg.PreStart, "pre", defaults.PreStart, "Pre-start command, exiting with code 1 without running the main command if it fails")
+	flag.StringVar(&cfg.PostStop, "post", defaults.PostStop, "Post-stop command, exiting with code 1 if it fails, unless the main command failed whose exit code takes precedence")
+	flag.StringVar(&cfg.BeforeExit, "before-exit", defaults.BeforeExit, "Command run right before ctx-init exits, even if the post-stop command failed, not changing the exit code")
+	flag.DurationVar(&cfg.BeforeExitTimeout, "before-exit-timeout", defaults.BeforeExitTimeout, "Timeout of the -before-exit command (0 = none)")
+	flag.StringVar(&cfg.PostStart, "post-start", defaults.PostStart, "Command run in the background once the main command started")
+	flag.DurationVar(&cfg.PostStartDelay, "post-start-delay", defaults.PostStartDelay, "Delay after the main command started before running -post-start, skipped if it exited meanwhile")
+	flag.BoolVar(&cfg.PostStartFatal, "post-start-fatal", defaults.PostStartFatal, "Terminate the main command and exit with code 1 if -post-start fails")
+	flag.StringVar(&cfg.Sidecar, "sidecar", defaults.Sidecar, "Command run in the background from before the main command starts, sent SIGTERM once it exits")
+	flag.StringVar(&cfg.PreStop, "pre-stop", defaults.PreStop, "Command run on SIGTERM, before the signal is forwarded to the main command")
+	flag.StringVar(&cfg.PostOn, "post-on", defaults.PostOn, "When to run the post-stop command: always, success or failure of the main command")
 	flag.BoolVar(&version, "version", false, "Display ctx-init version")
+	flag.BoolVar(&cfg.Shell, "shell", defaults.Shell, "Run -pre and -post commands with $SHELL -c (default /bin/sh) instead of splitting them into words")
+	flag.BoolVar(&cfg.ShellMain, "shell-main", defaults.ShellMain, "Run the main command with $SHELL -c, joining its arguments with spaces")
+	flag.Var((*stringList)(&cfg.RunCommands), "run", "Main command run in parallel with the main command of the arguments, if any, sharing its signals, hooks and restarts (repeatable)")
+	flag.StringVar(&cfg.ExitOn, "exit-on", defaults.ExitOn, "When several main commands stop: first, once one exited, the others being sent SIGTERM, or all, once all exited")
+	flag.BoolVar(&cfg.TTY, "tty", defaults.TTY, "Run the main command in a pseudo-terminal connected to stdin and stdout, resized on SIGWINCH")
+	flag.BoolVar(&cfg.MergeStderr, "merge-stderr", defaults.MergeStderr, "Write the stderr of the main command to stdout, as a single stream")
+	flag.BoolVar(&cfg.WrapOutput, "wrap-output", defaults.WrapOutput, "Log each line of output of the main command as a log event of ctx-init, with its stream (stdout or stderr)")
+	flag.IntVar(&cfg.WrapOutputMaxLine, "wrap-output-max-line", defaults.WrapOutputMaxLine, "Size in bytes above which lines of output are written as-is instead of wrapped by -wrap-output or buffered by -line-buffered (0 = no limit)")
+	flag.BoolVar(&cfg.LineBuffered, "line-buffered", defaults.LineBuffered, "Pipe the output of the main command through ctx-init, writing each line at once so lines of its streams don't interleave")
+	flag.BoolVar(&cfg.Interpolate, "interpolate", defaults.Interpolate, "Also resolve ${<secret-reference>} tokens anywhere in env var values")
+	flag.BoolVar(&cfg.Expand, "expand", defaults.Expand, "Expand $VAR and ${VAR} references in env var values once secrets are resolved")
+	flag.StringVar(&cfg.SecretSeparator, "secret-separator", defaults.SecretSeparator, "Separator of the fields of secret references, for names containing the default one")
+	flag.StringVar(&cfg.EnvPrefix, "env-prefix", defaults.EnvPrefix, "Only resolve secret references in env vars whose names start with this prefix (default all)")
+	flag.IntVar(&cfg.MaxEnvScanSize, "max-env-scan-size", defaults.MaxEnvScanSize, "Size in bytes above which env var values are not scanned for secret references (0 = no limit)")
+	flag.BoolVar(&cfg.ResolveArgs, "resolve-args", defaults.ResolveArgs, "Also resolve secret references in the main, pre-start and post-stop command arguments (visible in the process table)")
+	flag.BoolVar(&cfg.JSONEnvSkipExisting, "jsonenv-skip-existing", defaults.JSONEnvSkipExisting, "Keep the existing env vars colliding with keys of aws:sm:jsonenv: secrets instead of overriding them")
+	flag.BoolVar(&cfg.AWSSMAll, "aws-sm-all", defaults.AWSSMAll, "Allow aws:sm:all:get:<prefix> references, setting an env var for each secret listed under the prefix")
+	flag.BoolVar(&cfg.SecretsOptional, "secrets-optional", defaults.SecretsOptional, "Log secrets that can't be fetched as warnings and leave their references in place instead of exiting")
+	flag.BoolVar(&cfg.StrictSecrets, "strict-secrets", defaults.StrictSecrets, "Exit on malformed secret references instead of logging a warning and leaving them as-is")
+	flag.IntVar(&cfg.SecretRetries, "secret-retries", defaults.SecretRetries, "Number of retries for transient secret fetch failures")
+	flag.DurationVar(&cfg.SecretTimeout, "secret-timeout", defaults.SecretTimeout, "Timeout of each secret fetch attempt, retried once reached (0 = none)")
+	flag.IntVar(&cfg.SecretCircuitBreaker, "secret-circuit-breaker", defaults.SecretCircuitBreaker, "Number of consecutive secret fetch failures after which the remaining secrets are not fetched (0 = disabled)")
+	flag.IntVar(&cfg.SecretConcurrency, "secret-concurrency", defaults.SecretConcurrency, "Maximum number of secrets fetched concurrently")
+	flag.StringVar(&cfg.AWSRegion, "aws-region", defaults.AWSRegion, "AWS region, overriding the default config chain")
+	flag.StringVar(&cfg.AssumeRoleARN, "assume-role-arn", defaults.AssumeRoleARN, "ARN of an AWS role to assume with the default credentials to fetch AWS secrets and parameters (e.g. cross-account)")
+	flag.StringVar(&cfg.AssumeRoleExternalID, "assume-role-external-id", defaults.AssumeRoleExternalID, "External ID to assume the -assume-role-arn role with")
+	flag.StringVar(&cfg.AssumeRoleSessionName, "assume-role-session-name", defaults.AssumeRoleSessionName, "Session name to assume the -assume-role-arn role with")
+	flag.StringVar(&cfg.SMEndpoint, "sm-endpoint", defaults.SMEndpoint, "AWS Secrets Manager endpoint URL (default $AWS_SM_ENDPOINT)")
+	flag.StringVar(&cfg.Restart, "restart", defaults.Restart, "When to restart the main command: never, on-failure or always")
+	flag.IntVar(&cfg.MaxRestarts, "max-restarts", defaults.MaxRestarts, "Maximum number of main command restarts (0 = unlimited)")
+	flag.DurationVar(&cfg.RestartDelay, "restart-delay", defaults.RestartDelay, "Delay before restarting the main command")
+	flag.IntVar(&cfg.Nice, "nice", defaults.Nice, "Scheduling priority of the main command, from -20 (highest) to 19 (lowest), 0 leaves it unchanged")
+	flag.StringVar(&cfg.Umask, "umask", defaults.Umask, "Octal umask of the main command, like 0027 (default the umask of ctx-init)")
+	flag.StringVar(&cfg.Chdir, "chdir", defaults.Chdir, "Working directory of the pre-start, main and post-stop commands")
+	flag.StringVar(&cfg.User, "user", defaults.User, "User name or UID to run the main command as")
+	flag.StringVar(&cfg.Group, "group", defaults.Group, "Group name or GID to run the main command as (default the primary group of -user)")
+	flag.DurationVar(&cfg.Timeout, "timeout", defaults.Timeout, "Maximum duration of the main command before it is terminated with exit code 124 (0 = disabled)")
+	flag.DurationVar(&cfg.KillTimeout, "kill-timeout", defaults.KillTimeout, "Grace period after SIGTERM/SIGINT before sending SIGKILL (0 = disabled)")
+	flag.StringVar(&cfg.IgnoreSignals, "ignore-signals", defaults.IgnoreSignals, "Comma-separated signals not forwarded to commands (e.g. SIGUSR1,SIGHUP)")
+	flag.StringVar(&cfg.ForwardSignals, "forward-signals", defaults.ForwardSignals, "Comma-separated signals forwarded to commands, all others are not (default all but SIGPIPE)")
+	flag.Var((*stringList)(&cfg.RemapSignals), "remap-signal", "Signal forwarded as another one, like SIGTERM=SIGUSR1 (repeatable)")
+	flag.BoolVar(&cfg.ReloadOnHUP, "reload-on-hup", defaults.ReloadOnHUP, "Restart the main command on SIGHUP with its secrets resolved again, instead of forwarding SIGHUP")
+	flag.BoolVar(&cfg.NoForward, "no-forward", defaults.NoForward, "Do not forward any signal to commands, only sending them the timeout signals (for debugging)")
+	flag.StringVar(&cfg.SuccessCodes, "success-codes", defaults.SuccessCodes, "Comma-separated exit codes of the main command treated as success, exiting with 0 or the code after '=' (e.g. 24,2 or 24=100)")
+	flag.StringVar(&cfg.CleanExitSignals, "clean-exit-signals", defaults.CleanExitSignals, "Comma-separated signals a command killed by is not logged as failed (default SIGINT,SIGTERM,SIGKILL, SIGKILL only if sent by ctx-init or while terminating, never for OOM kills)")
+	flag.Var((*stringList)(&cfg.WaitFor), "wait-for", "host:port to wait for a TCP connection to before the pre-start command, on any address the host resolves to (repeatable)")
+	flag.Var((*stringList)(&cfg.WaitForHTTP), "wait-for-http", "URL to wait for a 2xx or 3xx response from before the pre-start command, after the -wait-for targets (repeatable)")
+	flag.DurationVar(&cfg.WaitTimeout, "wait-timeout", defaults.WaitTimeout, "Maximum duration to wait for the -wait-for and -wait-for-http targets (0 = forever)")
+	flag.StringVar(&cfg.DebugSignal, "debug-signal", defaults.DebugSignal, "Signal dumping the goroutine stacks of ctx-init to stderr instead of being forwarded (e.g. SIGUSR2)")
+	flag.StringVar(&cfg.EnvFile, "env-file", defaults.EnvFile, "File of KEY=VALUE lines loaded into the environment before secret resolution")
+	flag.Var((*stringList)(&cfg.Env), "env", "KEY=VALUE set in the environment before secret resolution, overriding inherited env vars and -env-file (repeatable)")
+	flag.Var((*stringList)(&cfg.UnsetEnv), "unset-env", "Glob of env vars removed from the environment of the main command, like AWS_* (repeatable)")
+	flag.Var((*stringList)(&cfg.KeepEnv), "keep-env", "Glob of env vars kept in the environment of the main command, with the secret-backed ones, all others are removed (repeatable)")
+	flag.BoolVar(&cfg.CleanEnv, "clean-env", defaults.CleanEnv, "Run the main command with only the secret-backed env vars and those kept with -keep-env, even if none")
+	flag.StringVar(&cfg.SecretsOut, "secrets-out", defaults.SecretsOut, "File (0600) to also write the secret-backed env vars to as KEY=VALUE lines, removed on exit")
+	flag.StringVar(&logFile, "log-file", "", "File to also append ctx-init logs to")
+	flag.IntVar(&cfg.LogSample, "log-sample", defaults.LogSample, "Only log 1 of every N debug lines about reaped zombies and forwarded signals (0 = log all)")
+	flag.BoolVar(&quiet, "quiet", false, "Only log errors, without colors, unless LOG_LEVEL is debug or trace")
+	flag.BoolVar(&logOnlyFile, "log-only-file", false, "Write ctx-init logs only to -log-file, not to stdout")
+	flag.BoolVar(&cfg.DryRun, "dry-run", defaults.DryRun, "Log the commands and the secret references that would be resolved, then exit without fetching or running anything")
+	flag.DurationVar(&cfg.ReapInterval, "reap-interval", defaults.ReapInterval, "Interval of the zombie reaping poll, in addition to reaping on SIGCHLD")
+	flag.BoolVar(&cfg.ReapOnly, "reap-only", defaults.ReapOnly, "Only run the main command, forwarding signals and reaping zombies, without secrets nor other commands")
+	flag.BoolVar(&cfg.NoReap, "no-reap", defaults.NoReap, "Do not reap zombies, for use as a wrapper when ctx-init is not PID 1")
+	flag.DurationVar(&cfg.ShutdownGrace, "shutdown-grace", defaults.ShutdownGrace, "Delay before ctx-init flushes its logs, metrics and traces and exits")
+	flag.StringVar(&cfg.PIDFile, "pidfile", defaults.PIDFile, "File to write the PID of the main command to once started, removed on exit")
+	flag.StringVar(&cfg.ReadyFile, "ready-file", defaults.ReadyFile, "File created once the main command ran for -ready-delay without exiting, removed when it exits")
+	flag.DurationVar(&cfg.ReadyDelay, "ready-delay", defaults.ReadyDelay, "Delay the main command must run for before -ready-file is created")
+	flag.StringVar(&cfg.HealthAddr, "health-addr", defaults.HealthAddr, "Address to serve /healthz on, reporting whether the main command is running (e.g. :8080)")
+	flag.StringVar(&cfg.GRPCHealthAddr, "grpc-health-addr", defaults.GRPCHealthAddr, "Address of the gRPC health service of the main command, whose status /healthz and -ready-file also reflect (e.g. localhost:50051)")
+	flag.StringVar(&cfg.GRPCHealthService, "grpc-health-service", defaults.GRPCHealthService, "Service name checked with -grpc-health-addr (default the whole server)")
+	flag.DurationVar(&cfg.GRPCHealthInterval, "grpc-health-interval", defaults.GRPCHealthInterval, "Interval between two checks of -grpc-health-addr")
+	flag.StringVar(&cfg.MetricsAddr, "metrics-addr", defaults.MetricsAddr, "Address to serve Prometheus /metrics on (e.g. :9090)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [--] command [args...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	// Parsing stops at "--" or at the first non-flag argument, everything
+	// after is the main command, even arguments looking like ctx-init flags
 	flag.Parse()
 
+	// The version is printed whatever the config file holds
 	if version {
-		fmt.Println(versionString)
+		printVersion(os.Stdout, strings.ToLower(os.Getenv("LOG_OUTPUT")) == "json")
 		os.Exit(0)
 	}
 
-	// Setup logging
-	logLevelStr := os.Getenv("LOG_LEVEL")
-	logLevel, err := zerolog.ParseLevel(strings.ToLower(logLevelStr))
-	if logLevelStr == "" || err != nil {
-		logLevel = zerolog.WarnLevel // Default to Info if LOG_LEVEL is not set or invalid
-	}
-	log.Logger = log.Level(logLevel).With().Str("component", component).Logger()
-	logOutput := os.Getenv("LOG_OUTPUT")
-	if logOutput == "nocolor" {
-		log.Logger = log.Logger.Output(zerolog.ConsoleWriter{Out: os.Stdout, NoColor: true})
-	} else if logOutput == "json" {
-		// log.Logger = log.Logger
-	} else {
-		log.Logger = log.Logger.Output(zerolog.ConsoleWriter{Out: os.Stdout})
-	}
-
-	// If no other args are provided, then we are missing the main command
-	if len(flag.Args()) == 0 {
-		log.Fatal().Msg("No main command defined, exiting")
-	}
-
-	// Create a map of environment variables
-	envMap := make(map[string]string)
-	awsSecretsFound := false
-	for _, envVar := range os.Environ() {
-		pair := strings.SplitN(envVar, "=", 2)
-		if len(pair) >= 2 {
-			// Check if the value starts with the aws:sm: prefix
-			if strings.HasPrefix(pair[1], awsSecretsPrefix) {
-				awsSecretsFound = true
-			}
-		}
-		if len(pair) == 2 {
-			envMap[pair[0]] = pair[1]
-		} else if len(pair) == 1 {
-			envMap[pair[0]] = "" // Handle env vars with no value
-		}
-	}
-
-	// Only initialize AWS config and Secrets Manager client if aws:sm: prefix is found
-	var secretsClient *secretsmanager.Client
-	if awsSecretsFound {
-		awsCfg, err := config.LoadDefaultConfig(context.TODO())
+	// Apply the config file to the flags not on the command line,
+	// the main command of the command line wins too
+	cfg.Command = flag.Args()
+	if configFile != "" {
+		command, err := loadConfig(flag.CommandLine, configFile)
 		if err != nil {
-			log.Fatal().Err(err).Msg("Cannot load the AWS configs")
-		}
-		secretsClient = secretsmanager.NewFromConfig(awsCfg)
-	} else {
-		log.Debug().Msg("No environment variables with 'aws:sm:' prefix found, skipping AWS Secrets Manager setup.")
-	}
-
-	// Override environment variables that are requesting a secret to be loaded
-	for envName, envValue := range envMap {
-		if strings.HasPrefix(envValue, awsSecretsPrefix) {
-			parts := strings.SplitN(envValue, separator, 5)
-			if len(parts) == 5 { // check for correct number of parts
-				provider := parts[0]
-				service := parts[1]
-				format := parts[2]
-				action := parts[3]
-				secretName := parts[4]
-				log.Debug().Str("envVar", envName).Str("provider", provider).Str("service", service).Str("type", format).Str("action", action).Str("name", secretName).Msg("Attempting to retrieve secret for env var")
-
-				if secretsClient != nil {
-					getSecretValueInput := &secretsmanager.GetSecretValueInput{
-						SecretId: aws.String(secretName),
-					}
-					result, err := secretsClient.GetSecretValue(context.TODO(), getSecretValueInput)
-					if err != nil {
-						log.Fatal().Err(err).Str("secretName", secretName).Str("envVar", envName).Msg("Failed to retrieve secret for env var")
-					}
-
-					// Set the environment variable with the retrieved secret value
-					os.Setenv(envName, *result.SecretString)
-					log.Debug().Str("envVar", envName).Msg("Set env var with secret value")
-				} else {
-					// This case should not happen if awsSecretsFound is true, but added for safety
-					log.Debug().Str("envVar", envName).Msg("Skipping secret retrieval as AWS Secrets Manager was not initialized.")
-				}
-			} else { // Corrected log message for malformed value
-				log.Warn().Str("envVar", envName).Msg("Ignoring environment variable with malformed 'aws:sm' prefix")
-			}
-		}
-	}
-
-	// Routine to reap zombies (it's the job of init)
-	ctx, cancel := context.WithCancel(context.Background())
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go removeZombies(ctx, &wg)
-
-	// Launch pre-start command
-	if preStartCmd == "" {
-		log.Debug().Msg("No pre-start command defined, skip")
-	} else {
-		log.Debug().Str("command", preStartCmd).Msg("Pre-start command launched")
-		preStartArgs, _ := parseArgs(preStartCmd)
-		if len(preStartArgs) == 0 {
-			log.Debug().Msg("Pre-start command is empty, skip")
-		} else if err := run(preStartArgs); err != nil {
-			log.Error().Msg("Pre-start command failed")
-			log.Error().Err(err).Send()
-			cleanQuit(cancel, &wg, 1)
-		} else {
-			log.Debug().Msg("Pre-start command exited")
-		}
-	}
-
-	// Launch main command
-	var mainRC int
-	// Pass the raw arguments captured by flag.Args() to run
-	mainArgs := flag.Args()
-	log.Debug().Str("command", strings.Join(mainArgs, " ")).Msg("Main command launched")
-	err = run(mainArgs)
-	if err != nil {
-		if isSuppressedError(err) {
-			log.Debug().Msg("Main command exited") // Suppress "failed"
-		} else {
-			log.Error().Msg("Main command failed")
-			log.Error().Err(err).Send()
-			mainRC = 1
-		}
-	} else {
-		log.Debug().Msg("Main command exited")
-	}
-
-	// Launch post-stop command
-	if postStopCmd == "" {
-		log.Debug().Msg("No post-stop command defined, skip")
-	} else {
-		log.Debug().Str("command", postStopCmd).Msg("Post-stop command launched")
-		postStopArgs, _ := parseArgs(postStopCmd)
-		if len(postStopArgs) == 0 {
-			log.Debug().Msg("Post-stop command is empty, skip")
-		} else if err := run(postStopArgs); err != nil {
-			log.Error().Msg("Post-stop command failed")
-			log.Error().Err(err).Send()
-			cleanQuit(cancel, &wg, 1)
-		} else {
-			log.Debug().Msg("Post-stop command exited")
-		}
-	}
-
-	// Wait removeZombies goroutine
-	cleanQuit(cancel, &wg, mainRC)
-}
-
-func removeZombies(ctx context.Context, wg *sync.WaitGroup) {
-	for {
-		var status syscall.WaitStatus
-
-		// Wait for orphaned zombie process
-		pid, _ := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
-
-		if pid <= 0 {
-			// PID is 0 or -1 if no child waiting
-			// so we wait for 1 second for next check
-			time.Sleep(1 * time.Second)
-		} else {
-			// PID is > 0 if a child was reaped
-			// we immediately check if another one
-			// is waiting
-			continue
-		}
-
-		// Non-blocking test
-		// if context is done
-		select {
-		case <-ctx.Done():
-			// Context is done
-			// so we stop goroutine
-			wg.Done()
-			return
-		default:
+			fmt.Fprintf(os.Stderr, "Invalid -config file: %v\n", err)
+			os.Exit(2)
 		}
-	}
-}
-
-func run(args []string) error {
-	if len(args) == 0 {
-		return nil // No command to run
-	}
-
-	commandStr := args[0]
-	argsSlice := args[1:]
-
-	// Register chan to receive system signals
-	sigs := make(chan os.Signal, 1)
-	defer close(sigs)
-	signal.Notify(sigs)
-	defer signal.Reset()
-
-	// Define command and rebind
-	// stdout and stdin
-	cmd := exec.Command(commandStr, argsSlice...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	// Create a dedicated pidgroup
-	// used to forward signals to
-	// main process and all children
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-
-	// Goroutine for signals forwarding
-	go func() {
-		for sig := range sigs {
-			// Ignore SIGCHLD signals since
-			// thez are only usefull for ctx-init
-			if cmd.Process != nil && sig != syscall.SIGCHLD {
-				// Forward signal to main process and all children
-				syscall.Kill(-cmd.Process.Pid, sig.(syscall.Signal))
-			}
+		if len(cfg.Command) == 0 {
+			cfg.Command = command
 		}
-	}()
-
-	// Start defined command
-	err := cmd.Start()
-	if err != nil {
-		return err
 	}
 
-	// Wait for command to exit
-	err = cmd.Wait()
-	if err != nil {
-		return err
+	// Setup logging
+	if err := ctxinit.SetupLogging(logFile, logOnlyFile, quiet); err != nil {
+		log.Fatal().Err(err).Str("logFile", logFile).Msg("Cannot open the log file")
 	}
 
-	return nil
-}
-
-func cleanQuit(cancel context.CancelFunc, wg *sync.WaitGroup, code int) {
-	// Signal zombie goroutine to stop
-	// and wait for it to release waitgroup
-	cancel()
-	wg.Wait()
-
+	// Run logs why it failed, if it did, only its exit code is left to use
+	code, _ := ctxinit.Run(context.Background(), cfg)
 	os.Exit(code)
 }
-
-// parseArgs parses a command string into a slice of arguments,
-// handling quoted strings and escaped characters.
-// This is a basic implementation and might not cover all edge cases.
-func parseArgs(command string) ([]string, error) {
-	var args []string
-	var currentArg strings.Builder
-	inQuotes := false
-
-	for i := 0; i < len(command); i++ {
-		char := command[i]
-
-		if char == '\\' && i+1 < len(command) {
-			currentArg.WriteByte(command[i+1])
-			i++
-		} else if char == '"' {
-			inQuotes = !inQuotes
-		} else if char == ' ' && !inQuotes {
-			if currentArg.Len() > 0 {
-				args = append(args, currentArg.String())
-				currentArg.Reset()
-			}
-		} else {
-			currentArg.WriteByte(char)
-		}
-	}
-	args = append(args, currentArg.String())
-	return args, nil
-}
-
-// isSuppressedError checks if the error indicates a termination that should suppress the "failed" message.
-func isSuppressedError(err error) bool {
-	if err == nil {
-		return true // Exited with status 0
-	}
-	if exitError, ok := err.(*exec.ExitError); ok {
-		if waitStatus, ok := exitError.Sys().(syscall.WaitStatus); ok {
-			// Suppress for SIGTERM, SIGKILL, or exit code 0
-			return waitStatus.Signaled() && (waitStatus.Signal() == syscall.SIGINT || waitStatus.Signal() == syscall.SIGTERM || waitStatus.Signal() == syscall.SIGKILL) || waitStatus.ExitStatus() == 0
-		}
-	}
-	return false // Any other error should not suppress "failed"
-}
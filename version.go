@@ -0,0 +1,44 @@
+/*
+Copyright 2025 ctx-init Contributors
+
+SPDX-License-Identifier: MIT
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// printVersion writes the version and build metadata to out, as a JSON
+// object when asJSON is set. The commit falls back to the VCS revision
+// recorded by the Go toolchain when not set at build time.
+func printVersion(out io.Writer, asJSON bool) {
+	revision := commit
+	if revision == "undefined" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" {
+					revision = setting.Value
+				}
+			}
+		}
+	}
+
+	if asJSON {
+		json.NewEncoder(out).Encode(map[string]string{
+			"version":   versionString,
+			"commit":    revision,
+			"buildDate": buildDate,
+			"goVersion": runtime.Version(),
+		})
+		return
+	}
+	fmt.Fprintln(out, versionString)
+	fmt.Fprintf(out, "commit:     %s\n", revision)
+	fmt.Fprintf(out, "build date: %s\n", buildDate)
+	fmt.Fprintf(out, "go version: %s\n", runtime.Version())
+}